@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"gopkg.in/yaml.v3"
@@ -17,17 +20,102 @@ const (
 	envUsername = "GSS_USERNAME"
 	envPassword = "GSS_PASSWORD"
 	envDomain   = "GSS_DOMAIN"
+
+	envTSIGName      = "TSIG_NAME"
+	envTSIGAlgorithm = "TSIG_ALGORITHM"
+	envTSIGSecret    = "TSIG_SECRET"
+
+	defaultTSIGAlgorithm = "hmac-sha256"
 )
 
+// Supported TSIG algorithms.
+var tsigAlgorithms = map[string]bool{
+	"hmac-sha1":   true,
+	"hmac-sha224": true,
+	"hmac-sha256": true,
+	"hmac-sha384": true,
+	"hmac-sha512": true,
+}
+
 type Config struct {
+	// If empty, the servers are discovered from each zone's SOA and NS records.
 	Servers []string         `yaml:"servers"`
 	Zones   map[string]*Zone `yaml:"zones"`
-	GSS     *GSSConfig       `yaml:"gss"`
+	// Records by absolute name in zones that are not configured.
+	// Records in a configured zone are moved to the zone when the config is read.
+	Records map[string]*Record `yaml:"records"`
+	Auth    `yaml:",inline"`
+	Retry   *RetryConfig `yaml:"retry"`
+	// Send updates without waiting for the responses to earlier ones (RFC 7766).
+	Pipelining bool `yaml:"pipelining"`
+	// The servers polled by --wait instead of each zone's NS records.
+	WaitServers []string `yaml:"wait_servers"`
+}
+
+// Auth is the authentication for updates. At most one method may be set.
+type Auth struct {
+	GSS  *GSSConfig  `yaml:"gss"`
+	TSIG *TSIGConfig `yaml:"tsig"`
+	SIG0 *SIG0Config `yaml:"sig0"`
+}
+
+// IsSet returns true if any authentication method is set.
+func (a *Auth) IsSet() bool {
+	return a.GSS != nil || a.TSIG != nil || a.SIG0 != nil
+}
+
+func (a *Auth) init() {
+	if a.TSIG != nil && a.TSIG.Algorithm == "" {
+		a.TSIG.Algorithm = defaultTSIGAlgorithm
+	}
+}
+
+func (a *Auth) Validate() error {
+	var auth int
+	for _, set := range []bool{a.GSS != nil, a.TSIG != nil, a.SIG0 != nil} {
+		if set {
+			auth++
+		}
+	}
+	if auth > 1 {
+		return errors.New("only one of gss, tsig and sig0 may be used")
+	}
+	if a.GSS != nil {
+		if err := a.GSS.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.TSIG != nil {
+		if err := a.TSIG.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.SIG0 != nil {
+		if err := a.SIG0.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the TSIG secret from its file if it has one.
+func (a *Auth) loadSecret() error {
+	if a.TSIG != nil {
+		return a.TSIG.loadSecret()
+	}
+	return nil
 }
 
 type Zone struct {
 	Records map[string]*Record `yaml:"records"`
 	TTL     uint32             `yaml:"ttl"`
+	Mode    string             `yaml:"mode"`
+	// Generate PTR records for the host records in the zone.
+	PTR bool `yaml:"ptr"`
+	// Override the global settings for this zone.
+	Servers     []string `yaml:"servers"`
+	Auth        `yaml:",inline"`
+	WaitServers []string `yaml:"wait_servers"`
 }
 
 // zoneName should be a FQDN.
@@ -35,6 +123,7 @@ func (z *Zone) init(zoneName string) {
 	if z.TTL == 0 {
 		z.TTL = defaultTTL
 	}
+	z.Auth.init()
 	for name, r := range z.Records {
 		if name == "@" {
 			r.FQDN = zoneName
@@ -44,6 +133,9 @@ func (z *Zone) init(zoneName string) {
 		if r.TTL == 0 {
 			r.TTL = z.TTL
 		}
+		if r.Mode == "" {
+			r.Mode = z.Mode
+		}
 	}
 }
 
@@ -51,6 +143,12 @@ func (z *Zone) Validate() error {
 	if len(z.Records) == 0 {
 		return errors.New("zone has no records")
 	}
+	if err := validateMode(z.Mode); err != nil {
+		return err
+	}
+	if err := z.Auth.Validate(); err != nil {
+		return err
+	}
 	for _, r := range z.Records {
 		if err := r.Validate(); err != nil {
 			return err
@@ -77,10 +175,27 @@ func ReadConfig(path string) (*Config, error) {
 	}
 
 	c.loadEnv()
+	if err := c.assignRecords(); err != nil {
+		return nil, err
+	}
 	c.init()
+	if err := c.resolveHosts(); err != nil {
+		return nil, err
+	}
+	if err := c.generatePTRs(); err != nil {
+		return nil, err
+	}
 	if err := c.Validate(); err != nil {
 		return nil, err
 	}
+	if err := c.Auth.loadSecret(); err != nil {
+		return nil, err
+	}
+	for name, z := range c.Zones {
+		if err := z.Auth.loadSecret(); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", name, err)
+		}
+	}
 	return c, nil
 }
 
@@ -88,6 +203,77 @@ func (c *Config) init() {
 	for name, z := range c.Zones {
 		z.init(dns.Fqdn(name))
 	}
+	for name, r := range c.Records {
+		r.FQDN = dns.Fqdn(name)
+		if r.TTL == 0 {
+			r.TTL = defaultTTL
+		}
+	}
+	c.Auth.init()
+}
+
+// Move the top-level records that are in a configured zone to the zone.
+func (c *Config) assignRecords() error {
+	for name, r := range c.Records {
+		zone, _ := c.enclosingZone(dns.Fqdn(name))
+		if zone == "" {
+			continue
+		}
+		if err := c.AddRecord(zone, name, r); err != nil {
+			return err
+		}
+		delete(c.Records, name)
+	}
+	return nil
+}
+
+// AddRecord adds the record r with the absolute name to zone, creating the
+// zone if it is not configured. The zone's defaults are applied to r.
+func (c *Config) AddRecord(zone string, name string, r *Record) error {
+	zoneKey, z := zone, c.Zones[zone]
+	for n, cz := range c.Zones {
+		if strings.EqualFold(dns.Fqdn(n), dns.Fqdn(zone)) {
+			zoneKey, z = n, cz
+		}
+	}
+	if z == nil {
+		z = &Zone{}
+		if c.Zones == nil {
+			c.Zones = map[string]*Zone{}
+		}
+		c.Zones[zoneKey] = z
+	}
+	if z.Records == nil {
+		z.Records = map[string]*Record{}
+	}
+
+	rel := relativeName(dns.Fqdn(name), dns.Fqdn(zoneKey))
+	if _, ok := z.Records[rel]; ok {
+		return fmt.Errorf("duplicate record %s", name)
+	}
+	z.Records[rel] = r
+	z.init(dns.Fqdn(zoneKey))
+	return nil
+}
+
+// Returns the longest configured zone that contains name.
+func (c *Config) enclosingZone(name string) (string, *Zone) {
+	var zoneName string
+	var zone *Zone
+	for n, z := range c.Zones {
+		if dns.IsSubDomain(dns.Fqdn(n), name) && len(dns.Fqdn(n)) > len(dns.Fqdn(zoneName)) {
+			zoneName, zone = n, z
+		}
+	}
+	return zoneName, zone
+}
+
+// Returns name relative to zone, or @ for the apex. Both must be FQDNs.
+func relativeName(name string, zone string) string {
+	if strings.EqualFold(name, zone) {
+		return "@"
+	}
+	return name[:len(name)-len(zone)-1]
 }
 
 // Load config from env variables.
@@ -111,24 +297,46 @@ func (c *Config) loadEnv() {
 			Domain:   os.Getenv(envDomain),
 		}
 	}
+
+	// tsig.Validate() will check that the rest are not empty.
+	if name := os.Getenv(envTSIGName); name != "" {
+		c.TSIG = &TSIGConfig{
+			Name:      name,
+			Algorithm: os.Getenv(envTSIGAlgorithm),
+			Secret:    os.Getenv(envTSIGSecret),
+		}
+	}
 }
 
 func (c *Config) Validate() error {
-	if len(c.Servers) == 0 {
-		return errors.New("servers must not be empty")
-	}
-	if len(c.Zones) == 0 {
-		return errors.New("zones cannot be empty")
+	if len(c.Zones) == 0 && len(c.Records) == 0 {
+		return errors.New("zones and records cannot both be empty")
 	}
-	for _, z := range c.Zones {
+	for name, z := range c.Zones {
 		if err := z.Validate(); err != nil {
-			return err
+			return fmt.Errorf("zone %s: %w", name, err)
+		}
+	}
+	for name, r := range c.Records {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("record %s: %w", name, err)
 		}
 	}
-	if c.GSS != nil {
-		if err := c.GSS.Validate(); err != nil {
+	if err := c.Auth.Validate(); err != nil {
+		return err
+	}
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
 			return err
 		}
+		for server, r := range c.Retry.Servers {
+			if len(r.Servers) > 0 {
+				return fmt.Errorf("retry policy for %q must not have servers", server)
+			}
+			if err := r.Validate(); err != nil {
+				return fmt.Errorf("retry policy for %q: %w", server, err)
+			}
+		}
 	}
 	return nil
 }
@@ -154,3 +362,126 @@ func (c *GSSConfig) Validate() error {
 	}
 	return nil
 }
+
+type TSIGConfig struct {
+	Name       string `yaml:"name"`
+	Algorithm  string `yaml:"algorithm"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+func (c *TSIGConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("TSIG name must not be empty")
+	}
+	if !tsigAlgorithms[strings.ToLower(c.Algorithm)] {
+		return fmt.Errorf("unsupported TSIG algorithm %q", c.Algorithm)
+	}
+	if (c.Secret == "") == (c.SecretFile == "") {
+		return errors.New("exactly one of TSIG secret and secret_file must be set")
+	}
+	if c.Secret != "" {
+		return validateSecret(c.Secret)
+	}
+	return nil
+}
+
+// Read the secret from SecretFile if it is set.
+func (c *TSIGConfig) loadSecret() error {
+	if c.SecretFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(c.SecretFile)
+	if err != nil {
+		return err
+	}
+	c.Secret = strings.TrimSpace(string(b))
+	return validateSecret(c.Secret)
+}
+
+func validateSecret(secret string) error {
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return fmt.Errorf("TSIG secret must be base64: %w", err)
+	}
+	return nil
+}
+
+type SIG0Config struct {
+	// Path to the K<name>+<alg>+<id>.private (or .key) file.
+	KeyFile string `yaml:"key_file"`
+}
+
+func (c *SIG0Config) Validate() error {
+	if c.KeyFile == "" {
+		return errors.New("SIG(0) key_file must not be empty")
+	}
+	return nil
+}
+
+// Retry actions.
+const (
+	RetryActionRetry    = "retry"
+	RetryActionFailover = "failover"
+	RetryActionFail     = "fail"
+)
+
+// RetryConfig overrides the default retry policy.
+// Unset fields keep their defaults.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Jitter         float64       `yaml:"jitter"`
+	// Maps rcode names (e.g. SERVFAIL) to actions.
+	Rcodes         map[string]string `yaml:"rcodes"`
+	TransportError string            `yaml:"transport_error"`
+	// Per server overrides of the policy above.
+	Servers map[string]*RetryConfig `yaml:"servers"`
+}
+
+func (c *RetryConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return errors.New("retry max_attempts must not be negative")
+	}
+	if c.InitialBackoff < 0 || c.MaxBackoff < 0 {
+		return errors.New("retry backoff must not be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	for rcode, action := range c.Rcodes {
+		if _, ok := dns.StringToRcode[strings.ToUpper(rcode)]; !ok {
+			return fmt.Errorf("invalid rcode %q", rcode)
+		}
+		if tsigRcodes[strings.ToUpper(rcode)] {
+			return fmt.Errorf("rcode %s is only used in TSIG and TKEY records and can't be retried", rcode)
+		}
+		if err := validateRetryAction(action); err != nil {
+			return err
+		}
+	}
+	if c.TransportError != "" {
+		return validateRetryAction(c.TransportError)
+	}
+	return nil
+}
+
+// The rcodes that are only sent in the error field of TSIG and TKEY records.
+// Responses with them have the NOTAUTH rcode.
+var tsigRcodes = map[string]bool{
+	"BADSIG":   true,
+	"BADKEY":   true,
+	"BADTIME":  true,
+	"BADMODE":  true,
+	"BADNAME":  true,
+	"BADALG":   true,
+	"BADTRUNC": true,
+}
+
+func validateRetryAction(a string) error {
+	switch a {
+	case RetryActionRetry, RetryActionFailover, RetryActionFail:
+		return nil
+	}
+	return fmt.Errorf("invalid retry action %q", a)
+}
@@ -6,8 +6,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
+var ptrTrue = func() *bool { b := true; return &b }()
+
 func TestReadConfig(t *testing.T) {
 	tests := map[string]struct {
 		want    *Config
@@ -54,11 +57,11 @@ func TestReadConfig(t *testing.T) {
 						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
 					},
 				},
-				GSS: &GSSConfig{
+				Auth: Auth{GSS: &GSSConfig{
 					Username: "username",
 					Password: "password",
 					Domain:   "domain",
-				},
+				}},
 			},
 		},
 		"gss_no_cred": {
@@ -70,7 +73,7 @@ func TestReadConfig(t *testing.T) {
 						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
 					},
 				},
-				GSS: &GSSConfig{},
+				Auth: Auth{GSS: &GSSConfig{}},
 			},
 		},
 		"apex": {
@@ -84,13 +87,213 @@ func TestReadConfig(t *testing.T) {
 				},
 			},
 		},
+		"replace": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:  defaultTTL,
+						Mode: ModeReplace,
+						Records: map[string]*Record{
+							"test":  {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL, Mode: ModeReplace},
+							"test2": {FQDN: "test2.example.com.", CNAME: "b", TTL: defaultTTL, Mode: ModeAppend},
+						},
+					},
+				},
+			},
+		},
+		"absent": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL: defaultTTL,
+						Records: map[string]*Record{
+							"name":   {FQDN: "name.example.com.", TTL: defaultTTL, State: StateAbsent},
+							"rrsets": {FQDN: "rrsets.example.com.", TTL: defaultTTL, State: StateAbsent, Types: []string{"a", "TXT"}},
+							"rr":     {FQDN: "rr.example.com.", TTL: defaultTTL, State: StateAbsent, Host: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+						},
+					},
+				},
+			},
+		},
+		"tsig": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+				},
+				Auth: Auth{TSIG: &TSIGConfig{
+					Name:      "key.example.com",
+					Algorithm: "hmac-sha512",
+					Secret:    "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI=",
+				}},
+			},
+		},
+		"tsig_secret_file": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+				},
+				Auth: Auth{TSIG: &TSIGConfig{
+					Name:       "key.example.com",
+					Algorithm:  defaultTSIGAlgorithm,
+					Secret:     "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI=",
+					SecretFile: "testdata/tsig.key",
+				}},
+			},
+		},
+		"sig0": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+				},
+				Auth: Auth{SIG0: &SIG0Config{KeyFile: "Kupdater.example.com.+013+12345.private"}},
+			},
+		},
+		"retry": {
+			want: &Config{
+				Servers: []string{"ns1.example.com", "ns2.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+				},
+				Retry: &RetryConfig{
+					MaxAttempts:    3,
+					InitialBackoff: 500 * time.Millisecond,
+					MaxBackoff:     10 * time.Second,
+					Jitter:         0.1,
+					Rcodes:         map[string]string{"SERVFAIL": RetryActionRetry, "NOTAUTH": RetryActionFail},
+					TransportError: RetryActionRetry,
+					Servers:        map[string]*RetryConfig{"ns2.example.com": {MaxAttempts: 1}},
+				},
+			},
+		},
+		"no_servers": {
+			want: &Config{
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+				},
+			},
+		},
+		"zone_override": {
+			want: &Config{
+				Servers: []string{"dc1.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"test": {FQDN: "test.example.com.", CNAME: "a", TTL: defaultTTL}},
+					},
+					"2.0.192.in-addr.arpa": {
+						TTL:         defaultTTL,
+						Records:     map[string]*Record{"1": {FQDN: "1.2.0.192.in-addr.arpa.", CNAME: "1.0-25.2.0.192.in-addr.arpa", TTL: defaultTTL}},
+						Servers:     []string{"ns.example.net"},
+						WaitServers: []string{"198.51.100.53"},
+						Auth: Auth{TSIG: &TSIGConfig{
+							Name:       "key.example.com",
+							Algorithm:  defaultTSIGAlgorithm,
+							Secret:     "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI=",
+							SecretFile: "testdata/tsig.key",
+						}},
+					},
+				},
+				Auth:        Auth{GSS: &GSSConfig{}},
+				Pipelining:  true,
+				WaitServers: []string{"192.0.2.53"},
+			},
+		},
+		"ptr": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL: defaultTTL,
+						PTR: true,
+						Records: map[string]*Record{
+							"www": {
+								FQDN: "www.example.com.",
+								TTL:  defaultTTL,
+								Host: []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+							},
+							"web":   {FQDN: "web.example.com.", TTL: defaultTTL, Host: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+							"noptr": {FQDN: "noptr.example.com.", TTL: defaultTTL, Host: []netip.Addr{netip.MustParseAddr("192.0.2.9")}, PTR: new(bool)},
+						},
+					},
+					"example.net": {
+						TTL: defaultTTL,
+						Records: map[string]*Record{
+							"www": {FQDN: "www.example.net.", TTL: 300, Host: []netip.Addr{netip.MustParseAddr("192.0.3.1")}, PTR: ptrTrue},
+						},
+					},
+					"0.192.in-addr.arpa": {
+						TTL: defaultTTL,
+						Records: map[string]*Record{
+							"1.3": {FQDN: "1.3.0.192.in-addr.arpa.", TTL: 300, ptr: []string{"www.example.net."}},
+						},
+					},
+					"2.0.192.in-addr.arpa": {
+						TTL: defaultTTL,
+						Records: map[string]*Record{
+							"1": {FQDN: "1.2.0.192.in-addr.arpa.", TTL: defaultTTL, ptr: []string{"web.example.com.", "www.example.com."}},
+						},
+					},
+					"8.b.d.0.1.0.0.2.ip6.arpa": {
+						TTL: defaultTTL,
+						Records: map[string]*Record{
+							"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": {
+								FQDN: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+								TTL:  defaultTTL,
+								ptr:  []string{"www.example.com."},
+							},
+						},
+					},
+				},
+			},
+		},
+		"records": {
+			want: &Config{
+				Servers: []string{"ns.example.com"},
+				Zones: map[string]*Zone{
+					"example.com": {
+						TTL: 600,
+						Records: map[string]*Record{
+							"test": {FQDN: "test.example.com.", CNAME: "a", TTL: 600},
+							"www":  {FQDN: "www.example.com.", Host: []netip.Addr{netip.MustParseAddr("192.0.2.1")}, TTL: 600},
+							"@":    {FQDN: "example.com.", TXT: []string{"hello"}, TTL: 600},
+						},
+					},
+					"sub.example.com": {
+						TTL:     defaultTTL,
+						Records: map[string]*Record{"a": {FQDN: "a.sub.example.com.", CNAME: "b", TTL: defaultTTL}},
+					},
+				},
+				Records: map[string]*Record{
+					"host.example.org": {FQDN: "host.example.org.", Host: []netip.Addr{netip.MustParseAddr("192.0.2.2")}, TTL: 60},
+				},
+			},
+		},
 		"gss_no_username": {wantErr: true},
 		"gss_no_password": {wantErr: true},
 		"gss_no_domain":   {wantErr: true},
 		"filenotfound":    {wantErr: true},
 		"wrong_type":      {wantErr: true},
 		"no_zones":        {wantErr: true},
-		"no_servers":      {wantErr: true},
 		"invalid_record":  {wantErr: true},
 		"zone_no_records": {wantErr: true},
 		"extra_key":       {wantErr: true},
@@ -98,6 +301,31 @@ func TestReadConfig(t *testing.T) {
 		"srv_invalid":     {wantErr: true},
 		"txt_empty_slice": {wantErr: true},
 		"cname_and_host":  {wantErr: true},
+		"mode_invalid":    {wantErr: true},
+		"types_present":   {wantErr: true},
+		"types_invalid":   {wantErr: true},
+		"state_invalid":   {wantErr: true},
+
+		"tsig_and_gss":               {wantErr: true},
+		"tsig_no_name":               {wantErr: true},
+		"tsig_invalid_algorithm":     {wantErr: true},
+		"tsig_no_secret":             {wantErr: true},
+		"tsig_secret_and_file":       {wantErr: true},
+		"tsig_invalid_secret":        {wantErr: true},
+		"tsig_secret_file_not_found": {wantErr: true},
+		"sig0_no_key_file":           {wantErr: true},
+		"sig0_and_tsig":              {wantErr: true},
+		"zone_auth_invalid":          {wantErr: true},
+		"ptr_no_zone":                {wantErr: true},
+		"ptr_cname":                  {wantErr: true},
+		"records_duplicate":          {wantErr: true},
+		"records_invalid":            {wantErr: true},
+		"retry_invalid_jitter":       {wantErr: true},
+		"retry_invalid_rcode":        {wantErr: true},
+		"retry_tsig_rcode":           {wantErr: true},
+		"retry_invalid_action":       {wantErr: true},
+		"retry_negative_attempts":    {wantErr: true},
+		"retry_nested_servers":       {wantErr: true},
 	}
 	for file, tc := range tests {
 		t.Run(file, func(t *testing.T) {
@@ -153,3 +381,20 @@ func TestConfigLoadEnvGSS(t *testing.T) {
 		t.Errorf("got domain %q, want %q", c.GSS.Domain, d)
 	}
 }
+
+func TestConfigLoadEnvTSIG(t *testing.T) {
+	n := "key.example.com"
+	a := "hmac-sha512"
+	s := "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI="
+	t.Setenv(envTSIGName, n)
+	t.Setenv(envTSIGAlgorithm, a)
+	t.Setenv(envTSIGSecret, s)
+
+	c := &Config{}
+	c.loadEnv()
+
+	want := &TSIGConfig{Name: n, Algorithm: a, Secret: s}
+	if !reflect.DeepEqual(c.TSIG, want) {
+		t.Errorf("got %#v, want %#v", c.TSIG, want)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// hostSource resolves the Host of a record when the config is read.
+type hostSource interface {
+	Validate() error
+	// Returns the addresses sorted.
+	addrs() ([]netip.Addr, error)
+}
+
+func validateFamily(family string) error {
+	switch family {
+	case "", FamilyIPv4, FamilyIPv6:
+		return nil
+	default:
+		return fmt.Errorf("invalid family %q", family)
+	}
+}
+
+// Returns network with 4 or 6 appended for the family.
+func familyNetwork(network string, family string) string {
+	switch family {
+	case FamilyIPv4:
+		return network + "4"
+	case FamilyIPv6:
+		return network + "6"
+	default:
+		return network
+	}
+}
+
+// Returns the sources of the record's Host that are set.
+func (r *Record) hostSources() []hostSource {
+	var ret []hostSource
+	if r.HostFromInterface != nil {
+		ret = append(ret, r.HostFromInterface)
+	}
+	if r.HostFromURL != nil {
+		ret = append(ret, r.HostFromURL)
+	}
+	if r.HostFromSTUN != nil {
+		ret = append(ret, r.HostFromSTUN)
+	}
+	return ret
+}
+
+// HasHostSource returns true if Host is set from an interface, URL or STUN server.
+func (r *Record) HasHostSource() bool {
+	return len(r.hostSources()) > 0
+}
+
+// Set the Host of the records with a host source to the source's addresses.
+// These records are replaced by default so that old addresses are removed.
+func (c *Config) resolveHosts() error {
+	return c.forEachHostSource(func(name string, r *Record) error {
+		sources := r.hostSources()
+		if len(sources) > 1 || len(r.Host) > 0 {
+			return fmt.Errorf("%s: only one of host, host_from_interface, host_from_url and host_from_stun can be set", name)
+		}
+		if err := sources[0].Validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		addrs, err := sources[0].addrs()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		r.Host = addrs
+		if r.Mode == "" {
+			r.Mode = ModeReplace
+		}
+		return nil
+	})
+}
+
+// Set the Host of the records with a source for which match returns true
+// to the source's current addresses. Records whose source fails are kept.
+// Returns the records whose addresses changed, even if there are errors.
+func (c *Config) updateHosts(match func(hostSource) bool) ([]*Record, error) {
+	var changed []*Record
+	var errs []error
+	_ = c.forEachHostSource(func(name string, r *Record) error {
+		s := r.hostSources()[0]
+		if !match(s) {
+			return nil
+		}
+		addrs, err := s.addrs()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return nil
+		}
+		if !slices.Equal(addrs, r.Host) {
+			r.Host = addrs
+			changed = append(changed, r)
+		}
+		return nil
+	})
+	return changed, errors.Join(errs...)
+}
+
+// Call f for every record with a host source.
+func (c *Config) forEachHostSource(f func(name string, r *Record) error) error {
+	for _, z := range c.Zones {
+		for _, r := range z.Records {
+			if len(r.hostSources()) == 0 {
+				continue
+			}
+			if err := f(r.FQDN, r); err != nil {
+				return err
+			}
+		}
+	}
+	for name, r := range c.Records {
+		if len(r.hostSources()) == 0 {
+			continue
+		}
+		if err := f(name, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
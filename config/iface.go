@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InterfaceSource sets the Host of a record to the addresses of a local network interface.
+// It can be given as just the name of the interface.
+type InterfaceSource struct {
+	Name string `yaml:"name"`
+	// ipv4 or ipv6. Both if empty.
+	Family string `yaml:"family"`
+	// Include addresses that are not global unicast addresses, such as link-local addresses.
+	AllScopes bool `yaml:"all_scopes"`
+	// Include temporary (privacy) and deprecated IPv6 addresses.
+	IncludeTemporary bool `yaml:"include_temporary"`
+	// Only include addresses in one of the prefixes if not empty.
+	CIDRs []netip.Prefix `yaml:"cidrs"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (s *InterfaceSource) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&s.Name)
+	}
+	// Without the UnmarshalYAML method to not recurse.
+	type source InterfaceSource
+	return value.Decode((*source)(s))
+}
+
+func (s *InterfaceSource) Validate() error {
+	if s.Name == "" {
+		return errors.New("interface name cannot be empty")
+	}
+	return validateFamily(s.Family)
+}
+
+// An address of an interface.
+type interfaceAddr struct {
+	addr netip.Addr
+	// For IPv6 addresses. Always false if unknown.
+	temporary  bool
+	deprecated bool
+}
+
+// Returns the addresses of the named interface. Replaced in tests.
+var interfaceAddrs = systemInterfaceAddrs
+
+func systemInterfaceAddrs(name string) ([]interfaceAddr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	flags := ipv6Flags(name)
+	ret := make([]interfaceAddr, 0, len(addrs))
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(n.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		ia := flags[addr]
+		ia.addr = addr
+		ret = append(ret, ia)
+	}
+	return ret, nil
+}
+
+// Returns the addresses of the interface that pass the filters, sorted.
+func (s *InterfaceSource) addrs() ([]netip.Addr, error) {
+	addrs, err := interfaceAddrs(s.Name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", s.Name, err)
+	}
+	var ret []netip.Addr
+	for _, a := range addrs {
+		switch {
+		case s.Family == FamilyIPv4 && !a.addr.Is4(),
+			s.Family == FamilyIPv6 && !a.addr.Is6(),
+			!s.AllScopes && !a.addr.IsGlobalUnicast(),
+			!s.IncludeTemporary && (a.temporary || a.deprecated),
+			len(s.CIDRs) > 0 && !slices.ContainsFunc(s.CIDRs, func(p netip.Prefix) bool { return p.Contains(a.addr) }):
+			continue
+		}
+		ret = append(ret, a.addr)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("interface %s has no matching addresses", s.Name)
+	}
+	slices.SortFunc(ret, netip.Addr.Compare)
+	return ret, nil
+}
+
+// UpdateInterfaceAddrs sets the Host of the records with an interface source
+// to the interface's current addresses and returns the records that changed.
+func (c *Config) UpdateInterfaceAddrs() ([]*Record, error) {
+	return c.updateHosts(func(s hostSource) bool {
+		_, ok := s.(*InterfaceSource)
+		return ok
+	})
+}
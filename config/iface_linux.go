@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Flags of IPv6 addresses in /proc/net/if_inet6.
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDeprecated = 0x20
+)
+
+// Returns the temporary and deprecated flags of the IPv6 addresses of the interface.
+func ipv6Flags(name string) map[netip.Addr]interfaceAddr {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseIfInet6(bufio.NewScanner(f), name)
+}
+
+// Each line is the address, interface index, prefix length, scope, flags and name.
+func parseIfInet6(s *bufio.Scanner, name string) map[netip.Addr]interfaceAddr {
+	ret := map[netip.Addr]interfaceAddr{}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(b)
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		ret[addr] = interfaceAddr{
+			temporary:  flags&ifaFlagTemporary != 0,
+			deprecated: flags&ifaFlagDeprecated != 0,
+		}
+	}
+	return ret
+}
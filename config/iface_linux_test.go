@@ -0,0 +1,29 @@
+package config
+
+import (
+	"bufio"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIfInet6(t *testing.T) {
+	const ifInet6 = `20010db8000000000000000000000001 02 40 00 80     eth0
+20010db8000000000000000000000003 02 40 00 01     eth0
+20010db8000000000000000000000004 02 40 00 20     eth0
+fe800000000000000000000000000001 02 40 20 80     eth0
+20010db8000000000000000000000005 03 40 00 01     eth1
+00000000000000000000000000000001 01 80 10 80       lo
+`
+	want := map[netip.Addr]interfaceAddr{
+		netip.MustParseAddr("2001:db8::1"): {},
+		netip.MustParseAddr("2001:db8::3"): {temporary: true},
+		netip.MustParseAddr("2001:db8::4"): {deprecated: true},
+		netip.MustParseAddr("fe80::1"):     {},
+	}
+	have := parseIfInet6(bufio.NewScanner(strings.NewReader(ifInet6)), "eth0")
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("got %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package config
+
+import "net/netip"
+
+// The flags of IPv6 addresses are only known on Linux.
+func ipv6Flags(string) map[netip.Addr]interfaceAddr {
+	return nil
+}
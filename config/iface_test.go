@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var testInterfaceAddrs = []interfaceAddr{
+	{addr: netip.MustParseAddr("2001:db8::2")},
+	{addr: netip.MustParseAddr("192.0.2.1")},
+	{addr: netip.MustParseAddr("2001:db8::1")},
+	{addr: netip.MustParseAddr("2001:db8:1::1")},
+	{addr: netip.MustParseAddr("2001:db8::3"), temporary: true},
+	{addr: netip.MustParseAddr("2001:db8::4"), deprecated: true},
+	{addr: netip.MustParseAddr("fe80::1")},
+	{addr: netip.MustParseAddr("127.0.0.1")},
+}
+
+// Set the addresses of eth0 until the end of the test.
+func setInterfaceAddrs(t *testing.T, addrs []interfaceAddr) {
+	t.Helper()
+	old := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = old })
+	interfaceAddrs = func(name string) ([]interfaceAddr, error) {
+		if name != "eth0" {
+			return nil, errors.New("no such interface")
+		}
+		return addrs, nil
+	}
+}
+
+func addrs(s ...string) []netip.Addr {
+	ret := make([]netip.Addr, len(s))
+	for i, a := range s {
+		ret[i] = netip.MustParseAddr(a)
+	}
+	return ret
+}
+
+func TestInterfaceSourceAddrs(t *testing.T) {
+	setInterfaceAddrs(t, testInterfaceAddrs)
+
+	tests := map[string]struct {
+		source  InterfaceSource
+		want    []netip.Addr
+		wantErr bool
+	}{
+		"default": {
+			source: InterfaceSource{Name: "eth0"},
+			want:   addrs("192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8:1::1"),
+		},
+		"ipv4": {
+			source: InterfaceSource{Name: "eth0", Family: FamilyIPv4},
+			want:   addrs("192.0.2.1"),
+		},
+		"ipv6": {
+			source: InterfaceSource{Name: "eth0", Family: FamilyIPv6},
+			want:   addrs("2001:db8::1", "2001:db8::2", "2001:db8:1::1"),
+		},
+		"all scopes": {
+			source: InterfaceSource{Name: "eth0", Family: FamilyIPv6, AllScopes: true},
+			want:   addrs("2001:db8::1", "2001:db8::2", "2001:db8:1::1", "fe80::1"),
+		},
+		"temporary": {
+			source: InterfaceSource{Name: "eth0", Family: FamilyIPv6, IncludeTemporary: true},
+			want:   addrs("2001:db8::1", "2001:db8::2", "2001:db8::3", "2001:db8::4", "2001:db8:1::1"),
+		},
+		"cidrs": {
+			source: InterfaceSource{Name: "eth0", CIDRs: []netip.Prefix{netip.MustParsePrefix("2001:db8::/64"), netip.MustParsePrefix("192.0.2.0/24")}},
+			want:   addrs("192.0.2.1", "2001:db8::1", "2001:db8::2"),
+		},
+		"no matches": {
+			source:  InterfaceSource{Name: "eth0", CIDRs: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}},
+			wantErr: true,
+		},
+		"unknown interface": {
+			source:  InterfaceSource{Name: "eth1"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			have, err := tc.source.addrs()
+			if err == nil && tc.wantErr {
+				t.Error("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %v, want %v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigInterface(t *testing.T) {
+	setInterfaceAddrs(t, testInterfaceAddrs)
+
+	tests := map[string]struct {
+		want    map[string]*Record
+		wantErr bool
+	}{
+		"iface": {
+			want: map[string]*Record{
+				"host.example.com.": {
+					FQDN:              "host.example.com.",
+					Host:              addrs("192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8:1::1"),
+					TTL:               defaultTTL,
+					Mode:              ModeReplace,
+					HostFromInterface: &InterfaceSource{Name: "eth0"},
+				},
+				"v6.example.com.": {
+					FQDN:              "v6.example.com.",
+					Host:              addrs("2001:db8::1", "2001:db8::2"),
+					TTL:               defaultTTL,
+					Mode:              ModeAppend,
+					HostFromInterface: &InterfaceSource{Name: "eth0", Family: FamilyIPv6, CIDRs: []netip.Prefix{netip.MustParsePrefix("2001:db8::/64")}},
+				},
+				"other.example.net.": {
+					FQDN:              "other.example.net.",
+					Host:              addrs("192.0.2.1"),
+					TTL:               defaultTTL,
+					Mode:              ModeReplace,
+					HostFromInterface: &InterfaceSource{Name: "eth0", Family: FamilyIPv4},
+				},
+			},
+		},
+		"iface_and_host":       {wantErr: true},
+		"iface_no_addrs":       {wantErr: true},
+		"iface_invalid_family": {wantErr: true},
+	}
+	for file, tc := range tests {
+		t.Run(file, func(t *testing.T) {
+			c, err := ReadConfig(filepath.Join("testdata", file+".yml"))
+			if err == nil && tc.wantErr {
+				t.Fatal("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if tc.wantErr {
+				return
+			}
+			have := map[string]*Record{}
+			for _, z := range c.Zones {
+				for _, r := range z.Records {
+					have[r.FQDN] = r
+				}
+			}
+			for _, r := range c.Records {
+				have[r.FQDN] = r
+			}
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %#v, want %#v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestUpdateInterfaceAddrs(t *testing.T) {
+	setInterfaceAddrs(t, testInterfaceAddrs)
+	c, err := ReadConfig(filepath.Join("testdata", "iface.yml"))
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if changed, err := c.UpdateInterfaceAddrs(); err != nil || len(changed) != 0 {
+		t.Errorf("got %d changed records and err=%v, want 0 and no error", len(changed), err)
+	}
+
+	// The order of the addresses doesn't matter.
+	reversed := make([]interfaceAddr, len(testInterfaceAddrs))
+	for i, a := range testInterfaceAddrs {
+		reversed[len(reversed)-1-i] = a
+	}
+	setInterfaceAddrs(t, reversed)
+	if changed, err := c.UpdateInterfaceAddrs(); err != nil || len(changed) != 0 {
+		t.Errorf("got %d changed records and err=%v, want 0 and no error", len(changed), err)
+	}
+
+	setInterfaceAddrs(t, slices.Concat(testInterfaceAddrs[1:], []interfaceAddr{{addr: netip.MustParseAddr("192.0.2.2")}}))
+	changed, err := c.UpdateInterfaceAddrs()
+	if err != nil || len(changed) == 0 {
+		t.Fatalf("got %d changed records and err=%v, want some and no error", len(changed), err)
+	}
+	if !slices.ContainsFunc(changed, func(r *Record) bool { return slices.Contains(r.Host, netip.MustParseAddr("192.0.2.2")) }) {
+		t.Errorf("expected 192.0.2.2 to be in the Host of a changed record")
+	}
+	// The addresses are only reported as changed once.
+	if changed, err := c.UpdateInterfaceAddrs(); err != nil || len(changed) != 0 {
+		t.Errorf("got %d changed records and err=%v, want 0 and no error", len(changed), err)
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// Add a PTR record to the reverse zone of each host address of the records
+// with ptr enabled. The reverse zone is the longest configured zone that
+// contains the address's in-addr.arpa or ip6.arpa name.
+// Must be called after the zones are initialized.
+func (c *Config) generatePTRs() error {
+	zoneNames := make([]string, 0, len(c.Zones))
+	for name := range c.Zones {
+		zoneNames = append(zoneNames, name)
+	}
+	// For a stable order of the PTR targets.
+	slices.Sort(zoneNames)
+
+	// Generated records by reverse name.
+	generated := map[string]*Record{}
+	for _, zoneName := range zoneNames {
+		z := c.Zones[zoneName]
+		names := make([]string, 0, len(z.Records))
+		for name := range z.Records {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			r := z.Records[name]
+			if !r.ptrEnabled(z.PTR) {
+				continue
+			}
+			if err := c.addPTRs(generated, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Records in zones that are not configured.
+	names := make([]string, 0, len(c.Records))
+	for name := range c.Records {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		r := c.Records[name]
+		if !r.ptrEnabled(false) {
+			continue
+		}
+		if err := c.addPTRs(generated, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) addPTRs(generated map[string]*Record, r *Record) error {
+	for _, ip := range r.Host {
+		if err := c.addPTR(generated, r, ip.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns r.PTR if it is set, otherwise zoneDefault.
+func (r *Record) ptrEnabled(zoneDefault bool) bool {
+	if r.PTR != nil {
+		return *r.PTR
+	}
+	return zoneDefault
+}
+
+// Add a PTR for ip to r.FQDN to the reverse zone, merging it with
+// any record already generated for ip.
+func (c *Config) addPTR(generated map[string]*Record, r *Record, ip string) error {
+	rev, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return err
+	}
+	zoneName, z := c.enclosingZone(rev)
+	if z == nil {
+		return fmt.Errorf("%s: no reverse zone for %s", r.FQDN, ip)
+	}
+
+	if ptr, ok := generated[rev]; ok {
+		if ptr.State != r.State || ptr.Mode != r.Mode {
+			return fmt.Errorf("%s: PTR for %s conflicts with another record's state or mode", r.FQDN, ip)
+		}
+		if !slices.Contains(ptr.ptr, r.FQDN) {
+			ptr.ptr = append(ptr.ptr, r.FQDN)
+		}
+		return nil
+	}
+
+	name := relativeName(rev, dns.Fqdn(zoneName))
+	ptr, ok := z.Records[name]
+	if !ok {
+		if z.Records == nil {
+			z.Records = map[string]*Record{}
+		}
+		ptr = &Record{FQDN: rev, TTL: r.TTL, Mode: r.Mode, State: r.State}
+		z.Records[name] = ptr
+	}
+	ptr.ptr = append(ptr.ptr, r.FQDN)
+	generated[rev] = ptr
+	return nil
+}
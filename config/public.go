@@ -0,0 +1,302 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	urlTimeout = 10 * time.Second
+	// The maximum size of a response from a URL source.
+	urlMaxBody = 64 << 10
+
+	stunDefaultPort = "3478"
+	stunTimeout     = 2 * time.Second
+	stunAttempts    = 3
+
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112a442
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+)
+
+// URLSource sets the Host of a record to the public address returned by
+// an HTTP echo service. It can be given as just the URL.
+type URLSource struct {
+	URL string `yaml:"url"`
+	// The dot separated path to the address in a JSON response, such as ip or data.0.address.
+	// The whole response is the address if empty.
+	JSONPath string `yaml:"json_path"`
+	// Connect over ipv4 or ipv6.
+	Family string `yaml:"family"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (s *URLSource) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&s.URL)
+	}
+	type source URLSource
+	return value.Decode((*source)(s))
+}
+
+func (s *URLSource) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q", s.URL)
+	}
+	return validateFamily(s.Family)
+}
+
+func (s *URLSource) addrs() ([]netip.Addr, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.Family != "" {
+		d := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, familyNetwork("tcp", s.Family), addr)
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: urlTimeout}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: got status %s", s.URL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, urlMaxBody))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.URL, err)
+	}
+
+	value := string(body)
+	if s.JSONPath != "" {
+		if value, err = jsonPath(body, s.JSONPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.URL, err)
+		}
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.URL, err)
+	}
+	if err := checkPublicAddr(addr, s.Family); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.URL, err)
+	}
+	return []netip.Addr{addr}, nil
+}
+
+// Returns the string at the dot separated path in the JSON document b.
+func jsonPath(b []byte, path string) (string, error) {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch e := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = e[key]; !ok {
+				return "", fmt.Errorf("key %q not found", key)
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(e) {
+				return "", fmt.Errorf("invalid index %q", key)
+			}
+			v = e[i]
+		default:
+			return "", fmt.Errorf("%q is not in an object or array", key)
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", path)
+	}
+	return s, nil
+}
+
+// Returns an error if addr is not a global unicast address
+// that isn't private or isn't in the family.
+func checkPublicAddr(addr netip.Addr, family string) error {
+	switch {
+	case !addr.IsGlobalUnicast() || addr.IsPrivate():
+		return fmt.Errorf("%s is not a public address", addr)
+	case family == FamilyIPv4 && !addr.Is4(), family == FamilyIPv6 && !addr.Is6():
+		return fmt.Errorf("%s is not an %s address", addr, family)
+	}
+	return nil
+}
+
+// STUNSource sets the Host of a record to the public address returned by
+// a STUN (RFC 5389) server. It can be given as just the server.
+type STUNSource struct {
+	// host:port. The port defaults to 3478.
+	Server string `yaml:"server"`
+	// Connect over ipv4 or ipv6.
+	Family string `yaml:"family"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (s *STUNSource) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&s.Server)
+	}
+	type source STUNSource
+	return value.Decode((*source)(s))
+}
+
+func (s *STUNSource) Validate() error {
+	if s.Server == "" {
+		return errors.New("stun server cannot be empty")
+	}
+	return validateFamily(s.Family)
+}
+
+// Returns the server with the default port if it doesn't have one.
+func (s *STUNSource) server() string {
+	if _, _, err := net.SplitHostPort(s.Server); err != nil {
+		return net.JoinHostPort(strings.Trim(s.Server, "[]"), stunDefaultPort)
+	}
+	return s.Server
+}
+
+func (s *STUNSource) addrs() ([]netip.Addr, error) {
+	server := s.server()
+	conn, err := net.DialTimeout(familyNetwork("udp", s.Family), server, stunTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req, stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	txID := req[8:]
+	_, _ = rand.Read(txID)
+
+	buf := make([]byte, 1500)
+	for range stunAttempts {
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("stun %s: %w", server, err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(stunTimeout)); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("stun %s: %w", server, err)
+			}
+			addr, ok, err := parseSTUNResponse(buf[:n], txID)
+			if err != nil {
+				return nil, fmt.Errorf("stun %s: %w", server, err)
+			}
+			// Not a response to the request.
+			if !ok {
+				continue
+			}
+			if err := checkPublicAddr(addr, s.Family); err != nil {
+				return nil, fmt.Errorf("stun %s: %w", server, err)
+			}
+			return []netip.Addr{addr}, nil
+		}
+	}
+	return nil, fmt.Errorf("stun %s: no response", server)
+}
+
+// Returns the mapped address in the binding response b to the request with txID.
+// ok is false if b isn't a response to the request.
+func parseSTUNResponse(b []byte, txID []byte) (addr netip.Addr, ok bool, err error) {
+	if len(b) < 20 || binary.BigEndian.Uint32(b[4:]) != stunMagicCookie || !bytes.Equal(b[8:20], txID) {
+		return netip.Addr{}, false, nil
+	}
+	if typ := binary.BigEndian.Uint16(b); typ != stunBindingSuccess {
+		return netip.Addr{}, true, fmt.Errorf("got message type %#04x", typ)
+	}
+	attrs := b[20:]
+	if length := int(binary.BigEndian.Uint16(b[2:])); length <= len(attrs) {
+		attrs = attrs[:length]
+	} else {
+		return netip.Addr{}, true, errors.New("truncated response")
+	}
+
+	// The key that XOR-MAPPED-ADDRESS is obfuscated with.
+	key := b[4:20]
+	var mapped netip.Addr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs)
+		length := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+length > len(attrs) {
+			return netip.Addr{}, true, errors.New("truncated attribute")
+		}
+		value := attrs[4 : 4+length]
+		switch typ {
+		case stunXORMappedAddress:
+			addr, err := parseSTUNAddress(value, key)
+			return addr, true, err
+		case stunMappedAddress:
+			if mapped, err = parseSTUNAddress(value, nil); err != nil {
+				return netip.Addr{}, true, err
+			}
+		}
+		// Attributes are padded to 4 bytes.
+		attrs = attrs[min(4+(length+3)&^3, len(attrs)):]
+	}
+	if !mapped.IsValid() {
+		return netip.Addr{}, true, errors.New("no mapped address in response")
+	}
+	return mapped, true, nil
+}
+
+// Parse a (XOR-)MAPPED-ADDRESS value. The address is XORed with key if it is not nil.
+func parseSTUNAddress(value []byte, key []byte) (netip.Addr, error) {
+	if len(value) < 4 {
+		return netip.Addr{}, errors.New("invalid mapped address")
+	}
+	ip := bytes.Clone(value[4:])
+	if key != nil && len(ip) <= len(key) {
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	// The family is 1 for IPv4 and 2 for IPv6.
+	if !ok || (value[1] == 1) != addr.Is4() {
+		return netip.Addr{}, errors.New("invalid mapped address")
+	}
+	return addr, nil
+}
+
+// UpdatePublicAddrs sets the Host of the records with a URL or STUN source
+// to the addresses the source returns and returns the records that changed.
+func (c *Config) UpdatePublicAddrs() ([]*Record, error) {
+	return c.updateHosts(func(s hostSource) bool {
+		_, ok := s.(*InterfaceSource)
+		return !ok
+	})
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestURLSourceAddrs(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		status   int
+		jsonPath string
+		want     []netip.Addr
+		wantErr  bool
+	}{
+		"plain": {
+			body: "192.0.2.1\n",
+			want: addrs("192.0.2.1"),
+		},
+		"json": {
+			body:     `{"ip": "192.0.2.1"}`,
+			jsonPath: "ip",
+			want:     addrs("192.0.2.1"),
+		},
+		"json nested": {
+			body:     `{"data": [{"address": "2001:db8::1"}]}`,
+			jsonPath: "data.0.address",
+			want:     addrs("2001:db8::1"),
+		},
+		"json missing key": {
+			body:     `{"ip": "192.0.2.1"}`,
+			jsonPath: "address",
+			wantErr:  true,
+		},
+		"json not a string": {
+			body:     `{"ip": 1}`,
+			jsonPath: "ip",
+			wantErr:  true,
+		},
+		"json invalid index": {
+			body:     `{"data": []}`,
+			jsonPath: "data.0",
+			wantErr:  true,
+		},
+		"not an address": {
+			body:    "<html></html>",
+			wantErr: true,
+		},
+		"private": {
+			body:    "10.0.0.1",
+			wantErr: true,
+		},
+		"loopback": {
+			body:    "::1",
+			wantErr: true,
+		},
+		"status": {
+			body:    "192.0.2.1",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				fmt.Fprint(w, tc.body)
+			}))
+			defer srv.Close()
+
+			s := &URLSource{URL: srv.URL, JSONPath: tc.jsonPath}
+			have, err := s.addrs()
+			if err == nil && tc.wantErr {
+				t.Error("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %v, want %v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckPublicAddr(t *testing.T) {
+	tests := map[string]struct {
+		addr    string
+		family  string
+		wantErr bool
+	}{
+		"ipv4":         {addr: "192.0.2.1"},
+		"ipv6":         {addr: "2001:db8::1", family: FamilyIPv6},
+		"private":      {addr: "172.16.0.1", wantErr: true},
+		"ula":          {addr: "fd00::1", wantErr: true},
+		"link local":   {addr: "fe80::1", wantErr: true},
+		"unspecified":  {addr: "0.0.0.0", wantErr: true},
+		"wrong family": {addr: "192.0.2.1", family: FamilyIPv6, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := checkPublicAddr(netip.MustParseAddr(tc.addr), tc.family)
+			if err == nil && tc.wantErr {
+				t.Error("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Errorf("expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+// A STUN attribute with the address.
+func stunAttr(typ uint16, addr netip.Addr, key []byte) []byte {
+	ip := addr.AsSlice()
+	family := byte(1)
+	if addr.Is6() {
+		family = 2
+	}
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(ip)))
+	b = append(b, 0, family, 0x12, 0x34)
+	for i := range ip {
+		if key != nil {
+			ip[i] ^= key[i]
+		}
+	}
+	return append(b, ip...)
+}
+
+// Start a STUN server that responds with the attributes returned by attrs.
+// The key passed to attrs is the one for XOR-MAPPED-ADDRESS.
+func stunServer(t *testing.T, attrs func(key []byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n != 20 || binary.BigEndian.Uint16(buf) != stunBindingRequest {
+				continue
+			}
+			a := attrs(buf[4:20])
+			resp := binary.BigEndian.AppendUint16(nil, stunBindingSuccess)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(a)))
+			resp = append(resp, buf[4:20]...)
+			_, _ = conn.WriteTo(append(resp, a...), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSTUNSourceAddrs(t *testing.T) {
+	tests := map[string]struct {
+		attrs   func(key []byte) []byte
+		want    []netip.Addr
+		wantErr bool
+	}{
+		"xor ipv4": {
+			attrs: func(key []byte) []byte {
+				return stunAttr(stunXORMappedAddress, netip.MustParseAddr("192.0.2.1"), key)
+			},
+			want: addrs("192.0.2.1"),
+		},
+		"xor ipv6": {
+			attrs: func(key []byte) []byte {
+				return stunAttr(stunXORMappedAddress, netip.MustParseAddr("2001:db8::1"), key)
+			},
+			want: addrs("2001:db8::1"),
+		},
+		"xor preferred": {
+			attrs: func(key []byte) []byte {
+				return append(
+					stunAttr(stunMappedAddress, netip.MustParseAddr("192.0.2.2"), nil),
+					stunAttr(stunXORMappedAddress, netip.MustParseAddr("192.0.2.1"), key)...,
+				)
+			},
+			want: addrs("192.0.2.1"),
+		},
+		"mapped": {
+			attrs: func([]byte) []byte {
+				return stunAttr(stunMappedAddress, netip.MustParseAddr("192.0.2.1"), nil)
+			},
+			want: addrs("192.0.2.1"),
+		},
+		"private": {
+			attrs: func(key []byte) []byte {
+				return stunAttr(stunXORMappedAddress, netip.MustParseAddr("192.168.0.1"), key)
+			},
+			wantErr: true,
+		},
+		"no address": {
+			attrs:   func([]byte) []byte { return nil },
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &STUNSource{Server: stunServer(t, tc.attrs)}
+			have, err := s.addrs()
+			if err == nil && tc.wantErr {
+				t.Error("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %v, want %v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestSTUNSourceServer(t *testing.T) {
+	tests := map[string]string{
+		"stun.example.com":      "stun.example.com:3478",
+		"stun.example.com:3479": "stun.example.com:3479",
+		"2001:db8::1":           "[2001:db8::1]:3478",
+		"[2001:db8::1]":         "[2001:db8::1]:3478",
+		"[2001:db8::1]:3479":    "[2001:db8::1]:3479",
+	}
+	for server, want := range tests {
+		if have := (&STUNSource{Server: server}).server(); have != want {
+			t.Errorf("%s: got %s, want %s", server, have, want)
+		}
+	}
+}
+
+func TestReadConfigPublic(t *testing.T) {
+	var addr atomic.Value
+	addr.Store("192.0.2.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `{"ip": %q}`, addr.Load())
+	}))
+	defer srv.Close()
+	stun := stunServer(t, func(key []byte) []byte {
+		return stunAttr(stunXORMappedAddress, netip.MustParseAddr("2001:db8::1"), key)
+	})
+
+	path := filepath.Join(t.TempDir(), "public.yml")
+	config := fmt.Sprintf(`---
+servers:
+  - ns.example.com
+zones:
+  example.com:
+    records:
+      url:
+        host_from_url:
+          url: %s
+          json_path: ip
+      stun:
+        host_from_stun: %s
+`, srv.URL, stun)
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	c, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	want := map[string]*Record{
+		"url": {
+			FQDN:        "url.example.com.",
+			Host:        addrs("192.0.2.1"),
+			TTL:         defaultTTL,
+			Mode:        ModeReplace,
+			HostFromURL: &URLSource{URL: srv.URL, JSONPath: "ip"},
+		},
+		"stun": {
+			FQDN:         "stun.example.com.",
+			Host:         addrs("2001:db8::1"),
+			TTL:          defaultTTL,
+			Mode:         ModeReplace,
+			HostFromSTUN: &STUNSource{Server: stun},
+		},
+	}
+	if have := c.Zones["example.com"].Records; !reflect.DeepEqual(have, want) {
+		t.Errorf("got %#v, want %#v", have, want)
+	}
+
+	if changed, err := c.UpdatePublicAddrs(); err != nil || len(changed) != 0 {
+		t.Errorf("got %d changed records and err=%v, want 0 and no error", len(changed), err)
+	}
+	addr.Store("192.0.2.2")
+	changed, err := c.UpdatePublicAddrs()
+	if err != nil || len(changed) != 1 {
+		t.Fatalf("got %d changed records and err=%v, want 1 and no error", len(changed), err)
+	}
+	if have := changed[0]; have != c.Zones["example.com"].Records["url"] || !slices.Equal(have.Host, addrs("192.0.2.2")) {
+		t.Errorf("got %s %v, want url.example.com. [192.0.2.2]", have.FQDN, have.Host)
+	}
+	// Interface sources are checked separately.
+	if changed, err := c.UpdateInterfaceAddrs(); err != nil || len(changed) != 0 {
+		t.Errorf("got %d changed records and err=%v, want 0 and no error", len(changed), err)
+	}
+}
@@ -12,6 +12,17 @@ import (
 
 const (
 	txtMaxLength = 255
+
+	// ModeAppend adds the records to any existing RRsets. This is the default.
+	ModeAppend = "append"
+	// ModeReplace replaces any existing RRsets with the records.
+	ModeReplace = "replace"
+
+	// StatePresent inserts the records. This is the default.
+	StatePresent = "present"
+	// StateAbsent removes the records. If no records or types are given,
+	// every record of the name is removed.
+	StateAbsent = "absent"
 )
 
 type Record struct {
@@ -22,6 +33,21 @@ type Record struct {
 	SRV   []SRVRecord  `yaml:"srv"`
 	CNAME string       `yaml:"cname"`
 	TTL   uint32       `yaml:"ttl"`
+	Mode  string       `yaml:"mode"`
+	State string       `yaml:"state"`
+	// RRsets to remove when State is absent.
+	Types []string `yaml:"types"`
+	// Generate PTR records for Host. Defaults to the zone's ptr.
+	PTR *bool `yaml:"ptr"`
+	// Set Host to the addresses of a local interface.
+	HostFromInterface *InterfaceSource `yaml:"host_from_interface"`
+	// Set Host to the public address returned by a URL.
+	HostFromURL *URLSource `yaml:"host_from_url"`
+	// Set Host to the public address returned by a STUN server.
+	HostFromSTUN *STUNSource `yaml:"host_from_stun"`
+
+	// PTR targets generated from the Host of other records.
+	ptr []string
 }
 
 type MXRecord struct {
@@ -65,13 +91,44 @@ func (r *Record) Validate() error {
 	if r.CNAME != "" {
 		typeCount++
 	}
+	if len(r.ptr) > 0 {
+		typeCount++
+	}
+
+	switch r.State {
+	case "", StatePresent:
+	case StateAbsent:
+		return r.validateTypes()
+	default:
+		return fmt.Errorf("invalid state %q", r.State)
+	}
 
+	if len(r.Types) > 0 {
+		return errors.New("types can only be used with state absent")
+	}
 	if typeCount == 0 {
 		return errors.New("must specify at least one type")
 	}
 	if r.CNAME != "" && typeCount > 1 {
 		return errors.New("cannot have other records with CNAME")
 	}
+	return validateMode(r.Mode)
+}
+
+func validateMode(mode string) error {
+	switch mode {
+	case "", ModeAppend, ModeReplace:
+		return nil
+	}
+	return fmt.Errorf("invalid mode %q", mode)
+}
+
+func (r *Record) validateTypes() error {
+	for _, t := range r.Types {
+		if _, ok := dns.StringToType[strings.ToUpper(t)]; !ok {
+			return fmt.Errorf("invalid type %q", t)
+		}
+	}
 	return nil
 }
 
@@ -194,6 +251,19 @@ func (r *Record) mx() []dns.RR {
 	return ret
 }
 
+func (r *Record) ptrs() []dns.RR {
+	ret := make([]dns.RR, 0, len(r.ptr))
+	for _, ptr := range r.ptr {
+		ret = append(ret,
+			&dns.PTR{
+				Hdr: r.header(dns.TypePTR),
+				Ptr: dns.Fqdn(ptr),
+			},
+		)
+	}
+	return ret
+}
+
 func (r *Record) srv() []dns.RR {
 	ret := make([]dns.RR, 0, len(r.SRV))
 	for _, srv := range r.SRV {
@@ -210,6 +280,20 @@ func (r *Record) srv() []dns.RR {
 	return ret
 }
 
+// Absent returns true if the records should be removed.
+func (r *Record) Absent() bool {
+	return r.State == StateAbsent
+}
+
+// RRsets returns a record without rdata for each of r.Types.
+func (r *Record) RRsets() []dns.RR {
+	ret := make([]dns.RR, 0, len(r.Types))
+	for _, t := range r.Types {
+		ret = append(ret, &dns.ANY{Hdr: r.header(dns.StringToType[strings.ToUpper(t)])})
+	}
+	return ret
+}
+
 func (r *Record) Records() []dns.RR {
 	ret := []dns.RR{}
 
@@ -217,6 +301,7 @@ func (r *Record) Records() []dns.RR {
 	ret = append(ret, r.txt()...)
 	ret = append(ret, r.mx()...)
 	ret = append(ret, r.srv()...)
+	ret = append(ret, r.ptrs()...)
 	if cname := r.cname(); cname != nil {
 		ret = append(ret, cname)
 	}
@@ -30,6 +30,19 @@ func TestRecords(t *testing.T) {
 				},
 			},
 		},
+		"PTR": {
+			r: &Record{FQDN: "1.2.0.192.in-addr.arpa.", ptr: []string{"a.example.com.", "b.example.com"}, TTL: 300},
+			want: []dns.RR{
+				&dns.PTR{
+					Hdr: dns.RR_Header{Name: "1.2.0.192.in-addr.arpa.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 300},
+					Ptr: "a.example.com.",
+				},
+				&dns.PTR{
+					Hdr: dns.RR_Header{Name: "1.2.0.192.in-addr.arpa.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 300},
+					Ptr: "b.example.com.",
+				},
+			},
+		},
 		"host multiple": {
 			r: &Record{
 				FQDN: "host." + testZone,
@@ -211,3 +224,14 @@ func TestSplitString(t *testing.T) {
 		})
 	}
 }
+
+func TestRRsets(t *testing.T) {
+	r := &Record{FQDN: "rrsets." + testZone, State: StateAbsent, Types: []string{"a", "TXT"}}
+	want := []dns.RR{
+		&dns.ANY{Hdr: dns.RR_Header{Name: "rrsets." + testZone, Rrtype: dns.TypeA, Class: dns.ClassINET}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: "rrsets." + testZone, Rrtype: dns.TypeTXT, Class: dns.ClassINET}},
+	}
+	if have := r.RRsets(); !reflect.DeepEqual(have, want) {
+		t.Errorf("got %+v, want %+v", have, want)
+	}
+}
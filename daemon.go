@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/config"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// How often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+// Add the flags of the serve command.
+func serveFlags(cmd *kingpin.CmdClause) *kingpin.CmdClause {
+	cmd.Flag("interval", "How often to apply the config. Records with a host source are only sent when their addresses change unless --sync is given.").Default("5m").DurationVar(&serveInterval)
+	cmd.Flag("jitter", "The fraction of the interval to randomly add or subtract from each wait.").Default("0.1").Float64Var(&serveJitter)
+	cmd.Flag("sync", "Apply the config like sync instead of insert.").BoolVar(&serveSync)
+	cmd.Flag("metrics-listen", "Serve Prometheus metrics on /metrics at the given address.").StringVar(&metricsListen)
+	return cmd
+}
+
+// Check the flags of the serve command.
+func validateServe() error {
+	if err := validateUpdate(); err != nil {
+		return err
+	}
+	switch {
+	case serveInterval <= 0:
+		return errors.New("--interval must be positive")
+	case serveJitter < 0 || serveJitter > 1:
+		return errors.New("--jitter must be between 0 and 1")
+	case exitError:
+		// The daemon would stop on the first error.
+		return errors.New("--exit-error can't be used with serve")
+	}
+	return nil
+}
+
+// daemon applies a config periodically and reloads it when the file changes.
+type daemon struct {
+	path     string
+	interval time.Duration
+	// The fraction of interval that each wait is randomly changed by.
+	jitter       float64
+	pollInterval time.Duration
+	sync         bool
+	// Returns the client for a config. The daemon closes it when the config is replaced.
+	newClient func(*config.Config) (zoneClient, error)
+
+	c      *config.Config
+	client zoneClient
+	// Of the config file when it was last read.
+	modTime time.Time
+	size    int64
+	// The last error checking the interface addresses so that
+	// it is only logged when it changes. Empty if there was none.
+	ifaceErr string
+}
+
+// Run the daemon for c, which was read from path, until ctx is done.
+// A value sent on reload reloads the config.
+func (d *daemon) run(ctx context.Context, reload <-chan os.Signal) {
+	d.modTime, d.size = d.stat()
+	d.apply(ctx)
+
+	timer := time.NewTimer(d.next())
+	defer timer.Stop()
+	poll := time.NewTicker(d.pollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+			if err := d.client.Close(); err != nil {
+				slog.Error("Error closing the updater", "err", err)
+			}
+			return
+		case <-reload:
+			slog.Info("Reloading the config")
+			d.reload(ctx)
+		case <-poll.C:
+			if modTime, size := d.stat(); !modTime.Equal(d.modTime) || size != d.size {
+				slog.Info("Config changed, reloading")
+				d.reload(ctx)
+			} else if changed := d.interfacesChanged(); len(changed) > 0 {
+				slog.Info("Interface addresses changed")
+				d.applyZones(ctx, changed)
+			}
+		case <-timer.C:
+			// Public addresses are only checked before each run as they are remote.
+			changed := d.publicAddrsChanged()
+			if len(changed) > 0 {
+				slog.Info("Public addresses changed")
+			}
+			if d.sync {
+				d.apply(ctx)
+			} else {
+				d.applyZones(ctx, d.periodicZones(changed))
+			}
+			timer.Reset(d.next())
+		}
+	}
+}
+
+// Returns the time to wait until the next run.
+func (d *daemon) next() time.Duration {
+	return d.interval + time.Duration(d.jitter*(2*rand.Float64()-1)*float64(d.interval))
+}
+
+// Returns the modification time and size of the config file or zero values if it can't be read.
+func (d *daemon) stat() (time.Time, int64) {
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		slog.Error("Error checking the config", "err", err)
+		return time.Time{}, 0
+	}
+	return fi.ModTime(), fi.Size()
+}
+
+// Update the addresses of the interface sources in the config.
+// Returns the zones with only the records whose addresses changed.
+func (d *daemon) interfacesChanged() map[string]*config.Zone {
+	records, err := d.c.UpdateInterfaceAddrs()
+	switch {
+	case err != nil && err.Error() != d.ifaceErr:
+		slog.Error("Error checking interface addresses", "err", err)
+		d.ifaceErr = err.Error()
+	case err == nil && d.ifaceErr != "":
+		slog.Info("Interface addresses are available again")
+		d.ifaceErr = ""
+	}
+	return d.zonesOf(records)
+}
+
+// Update the addresses of the URL and STUN sources in the config.
+// Returns the zones with only the records whose addresses changed.
+func (d *daemon) publicAddrsChanged() map[string]*config.Zone {
+	records, err := d.c.UpdatePublicAddrs()
+	if err != nil {
+		slog.Error("Error checking public addresses", "err", err)
+	}
+	return d.zonesOf(records)
+}
+
+// Returns the zones of the config with only records.
+func (d *daemon) zonesOf(records []*config.Record) map[string]*config.Zone {
+	return filterZones(d.c.Zones, func(_ string, _ string, r *config.Record) bool {
+		return slices.Contains(records, r)
+	})
+}
+
+// Read the config and apply it if it is valid. Otherwise, keep the current config.
+func (d *daemon) reload(ctx context.Context) {
+	// Don't reload an invalid config again until it changes.
+	d.modTime, d.size = d.stat()
+	c, err := config.ReadConfig(d.path)
+	if err != nil {
+		slog.Error("Error loading config, keeping the current config", "err", err)
+		return
+	}
+	client, err := d.newClient(c)
+	if err != nil {
+		slog.Error("Error creating the updater, keeping the current config", "err", err)
+		return
+	}
+	if err := inferZones(c, client); err != nil {
+		slog.Error("Error finding zones, keeping the current config", "err", err)
+		_ = client.Close()
+		return
+	}
+	if err := d.client.Close(); err != nil {
+		slog.Error("Error closing the updater", "err", err)
+	}
+	d.c, d.client = c, client
+	d.ifaceErr = ""
+	d.apply(ctx)
+}
+
+// Returns the zones to apply periodically. Records with a host source are
+// only sent when they are in changed, that is when their addresses change,
+// since they replace the RRsets with the same records every time.
+func (d *daemon) periodicZones(changed map[string]*config.Zone) map[string]*config.Zone {
+	return filterZones(d.c.Zones, func(zone string, name string, r *config.Record) bool {
+		if !r.HasHostSource() {
+			return true
+		}
+		z, ok := changed[zone]
+		return ok && z.Records[name] != nil
+	})
+}
+
+// Returns copies of zones with only the records for which keep returns true.
+// Zones without any records are left out.
+func filterZones(zones map[string]*config.Zone, keep func(zone string, name string, r *config.Record) bool) map[string]*config.Zone {
+	ret := map[string]*config.Zone{}
+	for zoneName, z := range zones {
+		records := map[string]*config.Record{}
+		for name, r := range z.Records {
+			if keep(zoneName, name, r) {
+				records[name] = r
+			}
+		}
+		if len(records) > 0 {
+			zc := *z
+			zc.Records = records
+			ret[zoneName] = &zc
+		}
+	}
+	return ret
+}
+
+// Apply the current config and write the report and metrics file of the run.
+// Every record is sent, including the ones with a host source.
+// Returns the number of errors.
+func (d *daemon) apply(ctx context.Context) int {
+	if !d.sync {
+		return d.applyZones(ctx, d.c.Zones)
+	}
+	slog.Info("Applying the config")
+	errs := syncZones(ctx, newSender(d.c, d.client), d.client, d.c.Zones, batchSize, batchBytes)
+	writeOutputs(errs)
+	return errs
+}
+
+// Insert the records in zones and write the report and metrics file of the run.
+// Returns the number of errors.
+func (d *daemon) applyZones(ctx context.Context, zones map[string]*config.Zone) int {
+	slog.Info("Applying the config")
+	errs := update(ctx, newSender(d.c, d.client), zones, batchSize, batchBytes, recordUpdate)
+	writeOutputs(errs)
+	return errs
+}
+
+// Run the daemon until ctx is done, reloading the config on SIGHUP.
+// u must have been created with ctx.
+func serve(ctx context.Context, c *config.Config, u zoneClient) int {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var failed atomic.Bool
+	if metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		srv := &http.Server{Addr: metricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Error serving metrics", "err", err)
+				failed.Store(true)
+				stop()
+			}
+		}()
+		defer srv.Close()
+	}
+
+	d := &daemon{
+		path:         *configFile,
+		interval:     serveInterval,
+		jitter:       serveJitter,
+		pollInterval: configPollInterval,
+		sync:         serveSync,
+		newClient: func(c *config.Config) (zoneClient, error) {
+			u, err := getUpdater(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			return u, nil
+		},
+		c:      c,
+		client: u,
+	}
+	d.run(ctx, hup)
+	if failed.Load() {
+		return 1
+	}
+	return 0
+}
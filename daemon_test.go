@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/config"
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testDaemonConfig = `---
+servers:
+  - ns.example.com
+zones:
+  example.com:
+    records:
+      www:
+        cname: a
+`
+	testDaemonConfig2 = `---
+servers:
+  - ns.example.com
+zones:
+  example.com:
+    records:
+      www:
+        cname: b
+      web:
+        cname: b
+`
+)
+
+// A testZoneClient that counts calls to Close
+// and sends the records of each update to sent if it isn't nil.
+type closingClient struct {
+	testZoneClient
+	closed *atomic.Int32
+	sent   chan<- []dns.RR
+}
+
+// Update implements updater.Updater
+func (c closingClient) Update(zone string, records []dns.RR) error {
+	err := c.testZoneClient.Update(zone, records)
+	if c.sent != nil {
+		c.sent <- records
+	}
+	return err
+}
+
+// Close implements updater.Updater
+func (c closingClient) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func newTestDaemon(t *testing.T) (*daemon, *atomic.Int32) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "records.yml")
+	if err := os.WriteFile(path, []byte(testDaemonConfig), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	c, err := config.ReadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	var closed atomic.Int32
+	newClient := func(*config.Config) (zoneClient, error) {
+		return closingClient{testZoneClient: testZoneClient{&testUpdater{}, &testTransferer{}}, closed: &closed}, nil
+	}
+	client, _ := newClient(c)
+	d := &daemon{
+		path:         path,
+		interval:     time.Hour,
+		pollInterval: time.Hour,
+		newClient:    newClient,
+		c:            c,
+		client:       client,
+	}
+	return d, &closed
+}
+
+// Returns the records sent to the daemon's current client.
+func clientRecords(d *daemon) int {
+	return len(d.client.(closingClient).testUpdater.allRecords)
+}
+
+func TestDaemonReload(t *testing.T) {
+	d, closed := newTestDaemon(t)
+	d.apply(context.Background())
+	if have := clientRecords(d); have != 1 {
+		t.Fatalf("got %d records, want 1", have)
+	}
+
+	// An invalid config is not applied.
+	if err := os.WriteFile(d.path, []byte("zones: []"), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	old := d.c
+	d.reload(context.Background())
+	if d.c != old {
+		t.Errorf("expected the current config to be kept")
+	}
+	if have := closed.Load(); have != 0 {
+		t.Errorf("expected the client not to be closed but it was closed %d times", have)
+	}
+
+	if err := os.WriteFile(d.path, []byte(testDaemonConfig2), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	// The current config is kept if the updater can't be created.
+	newClient := d.newClient
+	d.newClient = func(*config.Config) (zoneClient, error) { return nil, errors.New("no such key") }
+	d.reload(context.Background())
+	if d.c != old {
+		t.Errorf("expected the current config to be kept")
+	}
+	if have := closed.Load(); have != 0 {
+		t.Errorf("expected the client not to be closed but it was closed %d times", have)
+	}
+
+	d.newClient = newClient
+	d.reload(context.Background())
+	if have := len(d.c.Zones["example.com"].Records); have != 2 {
+		t.Errorf("got %d records in the config, want 2", have)
+	}
+	if have := closed.Load(); have != 1 {
+		t.Errorf("expected the old client to be closed once but it was closed %d times", have)
+	}
+	// The new config is applied with the new client.
+	if have := clientRecords(d); have != 2 {
+		t.Errorf("got %d records, want 2", have)
+	}
+}
+
+func TestDaemonRun(t *testing.T) {
+	d, closed := newTestDaemon(t)
+	// Only the reload channel triggers a run.
+	d.interval = time.Hour
+	d.pollInterval = time.Hour
+
+	sent := make(chan []dns.RR, 10)
+	newClient := d.newClient
+	d.newClient = func(c *config.Config) (zoneClient, error) {
+		client, err := newClient(c)
+		cc := client.(closingClient)
+		cc.sent = sent
+		return cc, err
+	}
+	cc := d.client.(closingClient)
+	cc.sent = sent
+	d.client = cc
+
+	// Waits for n updates.
+	waitUpdates := func(n int) {
+		t.Helper()
+		for range n {
+			select {
+			case <-sent:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for an update")
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx, reload)
+		close(done)
+	}()
+
+	// The config is applied at startup.
+	waitUpdates(1)
+
+	if err := os.WriteFile(d.path, []byte(testDaemonConfig2), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	reload <- syscall.SIGHUP
+	// One update for each of the two names.
+	waitUpdates(2)
+	cancel()
+	<-done
+
+	if have := len(d.c.Zones["example.com"].Records); have != 2 {
+		t.Errorf("got %d records in the config, want 2", have)
+	}
+	// The client of the first config and the client at shutdown.
+	if have := closed.Load(); have != 2 {
+		t.Errorf("got %d clients closed, want 2", have)
+	}
+	select {
+	case rrs := <-sent:
+		t.Errorf("got an unexpected update: %v", rrs)
+	default:
+	}
+}
+
+func TestDaemonApplyOutputs(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	dir := t.TempDir()
+	reportFile = filepath.Join(dir, "report.json")
+	metricsFile = filepath.Join(dir, "metrics.prom")
+	metrics = updater.NewMetrics()
+	defer func() { reportFile, metricsFile, report, metrics = "", "", nil, nil }()
+
+	// Each run is reported separately.
+	for range 2 {
+		d.apply(context.Background())
+		b, err := os.ReadFile(reportFile)
+		if err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+		var rep runReport
+		if err := json.Unmarshal(b, &rep); err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+		if rep.Totals.Updates != 1 {
+			t.Errorf("got %d updates in the report, want 1", rep.Totals.Updates)
+		}
+		if _, err := os.Stat(metricsFile); err != nil {
+			t.Errorf("expected the metrics file to be written but got: %v", err)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		wantErr bool
+	}{
+		"valid":          {args: []string{"serve", "--interval", "10s", "--jitter", "0.1"}},
+		"defaults":       {args: []string{"serve"}},
+		"max jitter":     {args: []string{"serve", "--jitter", "1"}},
+		"no jitter":      {args: []string{"serve", "--jitter", "0"}},
+		"zero interval":  {args: []string{"serve", "--interval", "0s"}, wantErr: true},
+		"jitter above 1": {args: []string{"serve", "--jitter", "1.5"}, wantErr: true},
+		"negative":       {args: []string{"serve", "--jitter", "-0.1"}, wantErr: true},
+		"exit error":     {args: []string{"serve", "--exit-error"}, wantErr: true},
+		"insert":         {args: []string{"insert", "--exit-error"}},
+		"batch bytes":    {args: []string{"sync", "--batch-bytes", "1500"}},
+		"max bytes":      {args: []string{"serve", "--batch-bytes", "65535"}},
+		"too few bytes":  {args: []string{"insert", "--batch-bytes", "1036"}, wantErr: true},
+		"too many bytes": {args: []string{"delete", "--batch-bytes", "65536"}, wantErr: true},
+		"negative bytes": {args: []string{"serve", "--batch-bytes=-1"}, wantErr: true},
+	}
+	defer func() { exitError, batchBytes = false, 0 }()
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			exitError, batchBytes = false, 0
+			_, err := parseArgs(tc.args)
+			if err == nil && tc.wantErr {
+				t.Error("expected an error")
+			} else if err != nil && !tc.wantErr {
+				t.Errorf("expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+// Returns the names of the records sent to the daemon's current client since the last call.
+func sentNames(d *daemon) []string {
+	u := d.client.(closingClient).testUpdater
+	var names []string
+	for _, rr := range u.allRecords {
+		if !slices.Contains(names, rr.Header().Name) {
+			names = append(names, rr.Header().Name)
+		}
+	}
+	u.allRecords = nil
+	slices.Sort(names)
+	return names
+}
+
+func TestDaemonHostSources(t *testing.T) {
+	var addr atomic.Value
+	addr.Store("192.0.2.1")
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		fmt.Fprint(w, addr.Load())
+	}))
+	defer srv.Close()
+
+	d, _ := newTestDaemon(t)
+	cfg := fmt.Sprintf(`---
+servers:
+  - ns.example.com
+zones:
+  example.com:
+    records:
+      www:
+        cname: a
+      home:
+        host_from_url: %s
+`, srv.URL)
+	if err := os.WriteFile(d.path, []byte(cfg), 0o600); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	d.reload(context.Background())
+	if have, want := sentNames(d), []string{"home.example.com.", "www.example.com."}; !slices.Equal(have, want) {
+		t.Errorf("got updates for %v, want %v", have, want)
+	}
+
+	// Unchanged records with a host source are not sent periodically.
+	if changed := d.publicAddrsChanged(); len(changed) > 0 {
+		t.Errorf("expected the public addresses to be unchanged")
+	}
+	d.applyZones(context.Background(), d.periodicZones(nil))
+	if have, want := sentNames(d), []string{"www.example.com."}; !slices.Equal(have, want) {
+		t.Errorf("got updates for %v, want %v", have, want)
+	}
+
+	addr.Store("192.0.2.2")
+	fetches.Store(0)
+	changed := d.publicAddrsChanged()
+	if len(changed) == 0 {
+		t.Fatalf("expected the public addresses to have changed")
+	}
+	if have := len(changed["example.com"].Records); have != 1 {
+		t.Errorf("got %d changed records, want 1", have)
+	}
+	d.applyZones(context.Background(), d.periodicZones(changed))
+	// The addresses that were checked are sent.
+	if have := fetches.Load(); have != 1 {
+		t.Errorf("got %d fetches, want 1", have)
+	}
+	if have, want := sentNames(d), []string{"home.example.com.", "www.example.com."}; !slices.Equal(have, want) {
+		t.Errorf("got updates for %v, want %v", have, want)
+	}
+	if have := d.c.Zones["example.com"].Records["home"].Host[0].String(); have != "192.0.2.2" {
+		t.Errorf("got %s, want 192.0.2.2", have)
+	}
+
+	// Only the changed records are sent when an address changes.
+	addr.Store("192.0.2.3")
+	d.applyZones(context.Background(), d.publicAddrsChanged())
+	if have, want := sentNames(d), []string{"home.example.com."}; !slices.Equal(have, want) {
+		t.Errorf("got updates for %v, want %v", have, want)
+	}
+}
+
+func TestDaemonInterfaceErrors(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	d, _ := newTestDaemon(t)
+	r := &config.Record{FQDN: "eth.example.com."}
+	d.c.Zones["example.com"].Records["eth"] = r
+
+	tests := []struct {
+		name string
+		// Set the interface source of the record if true.
+		source bool
+		want   string
+	}{
+		{name: "error", source: true, want: "Error checking interface addresses"},
+		{name: "same error", source: true},
+		{name: "recovered", want: "Interface addresses are available again"},
+		{name: "ok"},
+		{name: "error again", source: true, want: "Error checking interface addresses"},
+	}
+	for _, tc := range tests {
+		r.HostFromInterface = nil
+		if tc.source {
+			r.HostFromInterface = &config.InterfaceSource{Name: "dnsupdater-missing0"}
+		}
+		logs.Reset()
+		d.interfacesChanged()
+		if have := logs.String(); tc.want == "" && have != "" || !strings.Contains(have, tc.want) {
+			t.Errorf("%s: got logs %q, want %q", tc.name, have, tc.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/devon-mar/dnsupdater/config"
+)
+
+type zoneFinder interface {
+	FindZone(name string) (string, error)
+}
+
+// Moves the top-level records that aren't in a configured zone
+// to the zone that f finds for them.
+func inferZones(c *config.Config, f zoneFinder) error {
+	names := make([]string, 0, len(c.Records))
+	for name := range c.Records {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		zone, err := f.FindZone(name)
+		if err != nil {
+			return err
+		}
+		slog.Debug("found zone", "name", name, "zone", zone)
+		if err := c.AddRecord(zone, name, c.Records[name]); err != nil {
+			return fmt.Errorf("record %s: %w", name, err)
+		}
+		delete(c.Records, name)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/devon-mar/dnsupdater/config"
+)
+
+// Finds the zone of a name in zones.
+type testFinder struct {
+	zones map[string]string
+}
+
+// FindZone implements zoneFinder
+func (f *testFinder) FindZone(name string) (string, error) {
+	if z, ok := f.zones[name]; ok {
+		return z, nil
+	}
+	return "", errors.New("no zone for " + name)
+}
+
+func TestInferZones(t *testing.T) {
+	finder := &testFinder{zones: map[string]string{
+		"www.example.com": "example.com.",
+		"example.com":     "example.com.",
+		"www.example.net": "example.net.",
+	}}
+
+	tests := map[string]struct {
+		zones     map[string]*config.Zone
+		records   map[string]*config.Record
+		want      map[string]*config.Zone
+		wantError bool
+	}{
+		"new zones": {
+			records: map[string]*config.Record{
+				"www.example.com": {FQDN: "www.example.com.", CNAME: "a", TTL: 60},
+				"example.com":     {FQDN: "example.com.", CNAME: "b", TTL: 60},
+				"www.example.net": {FQDN: "www.example.net.", CNAME: "c", TTL: 60},
+			},
+			want: map[string]*config.Zone{
+				"example.com.": {
+					TTL: 3600,
+					Records: map[string]*config.Record{
+						"www": {FQDN: "www.example.com.", CNAME: "a", TTL: 60},
+						"@":   {FQDN: "example.com.", CNAME: "b", TTL: 60},
+					},
+				},
+				"example.net.": {
+					TTL:     3600,
+					Records: map[string]*config.Record{"www": {FQDN: "www.example.net.", CNAME: "c", TTL: 60}},
+				},
+			},
+		},
+		"configured zone": {
+			zones: map[string]*config.Zone{
+				"Example.com": {TTL: 600, Records: map[string]*config.Record{}},
+			},
+			records: map[string]*config.Record{
+				"www.example.com": {FQDN: "www.example.com.", CNAME: "a", TTL: 60},
+			},
+			want: map[string]*config.Zone{
+				"Example.com": {
+					TTL:     600,
+					Records: map[string]*config.Record{"www": {FQDN: "www.Example.com.", CNAME: "a", TTL: 60}},
+				},
+			},
+		},
+		"not found": {
+			records:   map[string]*config.Record{"www.example.org": {FQDN: "www.example.org.", CNAME: "a", TTL: 60}},
+			wantError: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &config.Config{Zones: tc.zones, Records: tc.records}
+			err := inferZones(c, finder)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if len(c.Records) != 0 {
+				t.Errorf("expected no records left but got %v", c.Records)
+			}
+			if !reflect.DeepEqual(c.Zones, tc.want) {
+				t.Errorf("got %#v, want %#v", c.Zones, tc.want)
+			}
+		})
+	}
+}
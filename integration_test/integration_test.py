@@ -129,3 +129,40 @@ def test_insert_batch(batch_size: int) -> None:
     )
 
     assert get_zone(TEST_ZONE) == WANT_RECORDS
+
+
+def test_delete() -> None:
+    config_file = Path(__file__).parent.joinpath("records.yml")
+    for cmd in ("insert", "delete"):
+        assert (
+            subprocess.call(
+                [
+                    str(UPDATER_BIN.absolute()),
+                    cmd,
+                    "--config",
+                    str(config_file.absolute()),
+                ],
+            )
+            == 0
+        )
+
+    assert get_zone(TEST_ZONE) == []
+
+
+def test_insert_verify() -> None:
+    clean_zone(TEST_ZONE)
+    config_file = Path(__file__).parent.joinpath("records.yml")
+    assert (
+        subprocess.call(
+            [
+                str(UPDATER_BIN.absolute()),
+                "insert",
+                "--config",
+                str(config_file.absolute()),
+                "--verify",
+            ],
+        )
+        == 0
+    )
+
+    assert get_zone(TEST_ZONE) == WANT_RECORDS
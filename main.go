@@ -1,8 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/devon-mar/dnsupdater/config"
 	"github.com/devon-mar/dnsupdater/updater"
@@ -15,98 +26,456 @@ var (
 	app        = kingpin.New("dnsupdater", "Insert DNS records from a file.")
 	configFile = app.Flag("config", "Path to the config file.").Default("records.yml").String()
 	checkCmd   = app.Command("check", "Check the config file.")
-	insertCmd  = app.Command("insert", "Insert records.")
-	batchSize  = insertCmd.Flag("batch", "Insert records in updates of the given size instead of per name.").Int()
-	exitError  = insertCmd.Flag("exit-error", "Stop on the first error when inserting records.").Bool()
+	insertCmd  = updateFlags(app.Command("insert", "Insert records."))
+	deleteCmd  = updateFlags(app.Command("delete", "Remove the records in the config."))
+	syncCmd    = updateFlags(app.Command("sync", "Insert records and remove records that are not in the config."))
+	planCmd    = app.Command("plan", "Show the changes insert would make. Exits with 2 if there are changes.")
+	planSync   = planCmd.Flag("sync", "Show the changes sync would make instead.").Bool()
+	serveCmd   = serveFlags(updateFlags(app.Command("serve", "Keep running, applying the config periodically and reloading it when it changes.")))
+
+	batchSize   int
+	batchBytes  int
+	exitError   bool
+	bisect      bool
+	dryRun      bool
+	parallel    int
+	verify      bool
+	wait        time.Duration
+	reportFile  string
+	metricsFile string
+
+	serveInterval time.Duration
+	serveJitter   float64
+	serveSync     bool
+	metricsListen string
+
+	// Set if --report is given.
+	report *reporter
+	// Set if --metrics-file or --metrics-listen is given.
+	metrics *updater.Metrics
 )
 
-func getUpdater(c *config.Config) updater.Updater {
-	slog.Info("using DNS servers", "servers", c.Servers)
-	u := updater.NewRFC2136(c.Servers)
-	if c.GSS != nil {
+// Add the flags common to commands that send updates.
+func updateFlags(cmd *kingpin.CmdClause) *kingpin.CmdClause {
+	cmd.Flag("batch", "Send records in updates of at most the given number of records instead of per name. RRsets are never split.").IntVar(&batchSize)
+	cmd.Flag("batch-bytes", "Send records in updates of at most the given number of bytes instead of per name. Defaults to 65535 with --batch.").IntVar(&batchBytes)
+	cmd.Flag("bisect", "When the server rejects the content of an update, split it to find the rejected RRsets and send the rest.").BoolVar(&bisect)
+	cmd.Flag("exit-error", "Stop on the first error when updating records.").BoolVar(&exitError)
+	cmd.Flag("dry-run", "Print the updates instead of sending them. sync and serve still contact the servers, as do insert and delete to find the zones of records outside the configured zones.").BoolVar(&dryRun)
+	cmd.Flag("parallel", "Number of zones to update concurrently.").Default("1").IntVar(&parallel)
+	cmd.Flag("verify", "Query the server after each update and fail if the records don't match.").BoolVar(&verify)
+	cmd.Flag("report", "Write a JSON report of the updates to the given file, or stdout if -. serve writes a report of each run.").StringVar(&reportFile)
+	cmd.Flag("metrics-file", "Write Prometheus metrics to the given file for the node_exporter textfile collector. serve writes it after each run.").StringVar(&metricsFile)
+	cmd.Flag("wait", "Wait up to the given duration for each zone's updates to reach all of its servers.").DurationVar(&wait)
+	return cmd
+}
+
+// Returns an updater that uses each zone's servers and authentication,
+// or the global ones if the zone doesn't set them.
+func getUpdater(ctx context.Context, c *config.Config) (*zoneUpdater, error) {
+	def, err := newUpdater(ctx, c, c.Servers, &c.Auth, slog.Default())
+	if err != nil {
+		return nil, err
+	}
+	u := &zoneUpdater{def: def, zones: map[string]zoneClient{}}
+	for name, z := range c.Zones {
+		if len(z.Servers) == 0 && !z.Auth.IsSet() {
+			continue
+		}
+		servers := z.Servers
+		if len(servers) == 0 {
+			servers = c.Servers
+		}
+		auth := &z.Auth
+		if !auth.IsSet() {
+			auth = &c.Auth
+		}
+		zu, err := newUpdater(ctx, c, servers, auth, slog.With("zone", name))
+		if err != nil {
+			_ = u.Close()
+			return nil, fmt.Errorf("zone %s: %w", name, err)
+		}
+		u.zones[dns.CanonicalName(name)] = zu
+	}
+	return u, nil
+}
+
+// Returns an updater for servers using auth and the global settings in c.
+func newUpdater(ctx context.Context, c *config.Config, servers []string, auth *config.Auth, logger *slog.Logger) (*updater.RFC2136Updater, error) {
+	if len(servers) > 0 {
+		logger.Info("using DNS servers", "servers", servers)
+	} else {
+		logger.Info("discovering DNS servers from each zone's SOA")
+	}
+	u := updater.NewRFC2136(servers)
+	if auth.GSS != nil {
 		if err := u.WithGSS(); err != nil {
-			slog.Error("error initializing GSS", "err", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("error initializing GSS: %w", err)
 		}
-		if c.GSS.Username != "" {
+		if auth.GSS.Username != "" {
 			// c.Validate() already made sure that the reset of the fields are not empty
-			u.WithCredentials(c.GSS.Username, c.GSS.Password, c.GSS.Domain)
+			u.WithCredentials(auth.GSS.Username, auth.GSS.Password, auth.GSS.Domain)
+		}
+	}
+	if auth.TSIG != nil {
+		u.WithTSIG(auth.TSIG.Name, auth.TSIG.Algorithm, auth.TSIG.Secret)
+	}
+	if auth.SIG0 != nil {
+		if err := u.WithSIG0(auth.SIG0.KeyFile); err != nil {
+			_ = u.Close()
+			return nil, fmt.Errorf("error loading SIG(0) key: %w", err)
+		}
+	}
+	if c.Pipelining {
+		u.WithPipelining()
+	}
+	u.WithContext(ctx)
+	if verify {
+		u.WithVerify()
+	}
+	u.WithMetrics(metrics)
+	if c.Retry != nil {
+		global := retryPolicy(updater.DefaultRetryPolicy(), c.Retry)
+		u.WithRetry("", global)
+		for server, rc := range c.Retry.Servers {
+			u.WithRetry(server, retryPolicy(global, rc))
 		}
 	}
-	return u
+	return u, nil
+}
+
+var retryActions = map[string]updater.Action{
+	config.RetryActionRetry:    updater.ActionRetry,
+	config.RetryActionFailover: updater.ActionFailover,
+	config.RetryActionFail:     updater.ActionFail,
+}
+
+// Returns a copy of base with the fields set in c overridden.
+func retryPolicy(base *updater.RetryPolicy, c *config.RetryConfig) *updater.RetryPolicy {
+	p := *base
+	if c.MaxAttempts != 0 {
+		p.MaxAttempts = c.MaxAttempts
+	}
+	if c.InitialBackoff != 0 {
+		p.InitialBackoff = c.InitialBackoff
+	}
+	if c.MaxBackoff != 0 {
+		p.MaxBackoff = c.MaxBackoff
+	}
+	if c.Jitter != 0 {
+		p.Jitter = c.Jitter
+	}
+	if c.TransportError != "" {
+		p.TransportError = retryActions[c.TransportError]
+	}
+	p.Rcodes = make(map[int]updater.Action, len(base.Rcodes)+len(c.Rcodes))
+	maps.Copy(p.Rcodes, base.Rcodes)
+	// c.Validate() already checked the rcodes and actions.
+	for rcode, a := range c.Rcodes {
+		p.Rcodes[dns.StringToRcode[strings.ToUpper(rcode)]] = retryActions[a]
+	}
+	return &p
+}
+
+// Parse the command line and check the flags of the command.
+// Flags are checked here since kingpin validates commands before setting their flags.
+func parseArgs(args []string) (string, error) {
+	cmd, err := app.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	switch cmd {
+	case serveCmd.FullCommand():
+		err = validateServe()
+	case insertCmd.FullCommand(), deleteCmd.FullCommand(), syncCmd.FullCommand():
+		err = validateUpdate()
+	}
+	return cmd, err
+}
+
+// Check the flags added by updateFlags.
+func validateUpdate() error {
+	if batchBytes != 0 && (batchBytes < updater.MinBatchSize || batchBytes > dns.MaxMsgSize) {
+		return fmt.Errorf("--batch-bytes must be between %d and %d", updater.MinBatchSize, dns.MaxMsgSize)
+	}
+	return nil
 }
 
 func main() {
-	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
+	cmd := kingpin.MustParse(parseArgs(os.Args[1:]))
 	c, err := config.ReadConfig(*configFile)
 	if err != nil {
 		slog.Error("Error loading config", "err", err)
 		os.Exit(1)
 	}
-	u := getUpdater(c)
+	if metricsFile != "" || metricsListen != "" {
+		metrics = updater.NewMetrics()
+	}
+	if dryRun && len(c.Records) == 0 && (cmd == insertCmd.FullCommand() || cmd == deleteCmd.FullCommand()) {
+		// Nothing needs to be looked up or transferred.
+		exit(dryRunUpdate(c, cmd))
+	}
+	ctx := context.Background()
+	if cmd == serveCmd.FullCommand() {
+		// So that a run is stopped between updates instead of finishing it.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+		defer stop()
+	}
+	u, err := getUpdater(ctx, c)
+	if err != nil {
+		slog.Error("Error creating the updater", "err", err)
+		os.Exit(1)
+	}
 	defer u.Close()
 
+	if cmd != checkCmd.FullCommand() {
+		if err := inferZones(c, u); err != nil {
+			slog.Error("Error finding zones", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	s := newSender(c, u)
+
 	switch cmd {
 	case checkCmd.FullCommand():
 		slog.Info("Config is valid.")
 	case insertCmd.FullCommand():
-		if *batchSize != 0 {
-			exit(insertBatch(u, c.Zones, *batchSize))
-		} else {
-			exit(insert(u, c.Zones))
-		}
+		exit(update(ctx, s, c.Zones, batchSize, batchBytes, recordUpdate))
+	case deleteCmd.FullCommand():
+		exit(update(ctx, s, c.Zones, batchSize, batchBytes, recordRemoval))
+	case syncCmd.FullCommand():
+		exit(syncZones(ctx, s, u, c.Zones, batchSize, batchBytes))
+	case planCmd.FullCommand():
+		exit(plan(u, c.Zones, *planSync, os.Stdout))
+	case serveCmd.FullCommand():
+		// serve writes the report and metrics after each run and closes u.
+		os.Exit(serve(ctx, c, u))
 	}
 }
 
-func insert(s updater.Updater, zones map[string]*config.Zone) int {
-	var ret int
-	for zoneName, zone := range zones {
-		slog.Info("Inserting records", "zone", zoneName)
+// Print the updates of insert or delete for c without creating an updater.
+func dryRunUpdate(c *config.Config, cmd string) int {
+	s := newSender(c, nil)
+	f := recordUpdate
+	if cmd == deleteCmd.FullCommand() {
+		f = recordRemoval
+	}
+	return update(context.Background(), s, c.Zones, batchSize, batchBytes, f)
+}
+
+// Returns the updater to send the updates for c with,
+// which is u unless --dry-run, --report or --wait is given.
+func newSender(c *config.Config, u zoneClient) updater.Updater {
+	var s updater.Updater = u
+	if dryRun {
+		s = updater.NewDryRun(os.Stdout)
+	}
+	if reportFile != "" {
+		// serve reports each run separately.
+		report = newReporter(s)
+		s = report
+	}
+	if !dryRun && wait > 0 {
+		s = newWaitUpdater(s, u, c, wait)
+	}
+	return s
+}
+
+// Returns the update section for a record.
+type recordUpdateFunc func(*config.Record) []dns.RR
+
+// Send the update section returned by f for every record
+// in batches (see sendBatches) or per name if batching is disabled.
+func update(ctx context.Context, s updater.Updater, zones map[string]*config.Zone, batchSize int, batchBytes int, f recordUpdateFunc) int {
+	if batching(batchSize, batchBytes) {
+		return updateZonesBatch(ctx, s, zones, batchSize, batchBytes, f)
+	}
+	return updateZones(ctx, s, zones, f)
+}
+
+func updateZones(ctx context.Context, s updater.Updater, zones map[string]*config.Zone, f recordUpdateFunc) int {
+	return forEachZone(ctx, zones, func(zoneName string, zone *config.Zone) int {
+		var ret int
+		slog.Info("Updating records", "zone", zoneName)
 		for _, r := range zone.Records {
+			if ctx.Err() != nil {
+				break
+			}
 			logger := slog.With("fqdn", r.FQDN, "zone", zoneName)
-			ret += insertRecords(s, zoneName, r.Records(), logger)
+			ret += updateRecords(s, zoneName, f(r), logger)
 		}
-	}
-	return ret
+		return ret + waitZone(s, zoneName, slog.With("zone", zoneName))
+	})
 }
 
-func insertBatch(s updater.Updater, zones map[string]*config.Zone, batchSize int) int {
-	var ret int
-	for zoneName, zone := range zones {
+func updateZonesBatch(ctx context.Context, s updater.Updater, zones map[string]*config.Zone, batchSize int, batchBytes int, f recordUpdateFunc) int {
+	return forEachZone(ctx, zones, func(zoneName string, zone *config.Zone) int {
 		logger := slog.With("zone", zoneName)
-		logger.Info("Insering records")
+		logger.Info("Updating records")
+		// In a stable order so that the batches are the same on every run.
 		var queue []dns.RR
-		for _, r := range zone.Records {
-			queue = append(queue, r.Records()...)
+		for _, name := range slices.Sorted(maps.Keys(zone.Records)) {
+			queue = append(queue, f(zone.Records[name])...)
+		}
+		return sendBatches(ctx, s, zoneName, queue, batchSize, batchBytes, logger) + waitZone(s, zoneName, logger)
+	})
+}
 
-			for len(queue) >= batchSize {
-				ret += insertRecords(s, zoneName, queue[:batchSize], logger)
-				queue = queue[batchSize:]
-			}
+// Call f for every zone with at most parallel zones at a time
+// and return the sum of the error counts returned by f.
+// Zones that haven't been started when ctx is done are skipped.
+// Updates within a zone are always sent in order since deletions must
+// be sent before insertions.
+func forEachZone(ctx context.Context, zones map[string]*config.Zone, f func(string, *config.Zone) int) int {
+	workers := max(parallel, 1)
+	var ret atomic.Int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for zoneName, zone := range zones {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
 		}
-		if len(queue) > 0 {
-			ret += insertRecords(s, zoneName, queue, logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ret.Add(int64(f(zoneName, zone)))
+		}()
+	}
+	wg.Wait()
+	return int(ret.Load())
+}
+
+// Returns the update section that inserts r, or removes it if r is absent.
+func recordUpdate(r *config.Record) []dns.RR {
+	if r.Absent() {
+		return recordRemoval(r)
+	}
+	records := r.Records()
+	if r.Mode == config.ModeReplace {
+		return append(updater.RemoveRRset(records), records...)
+	}
+	return records
+}
+
+// Returns the update section that removes r.
+func recordRemoval(r *config.Record) []dns.RR {
+	records := r.Records()
+	if r.Absent() && len(records) == 0 && len(r.Types) == 0 {
+		return updater.RemoveName([]string{r.FQDN})
+	}
+	return append(updater.RemoveRRset(r.RRsets()), updater.Remove(records)...)
+}
+
+// Returns true if records should be sent in batches instead of per name.
+func batching(batchSize int, batchBytes int) bool {
+	return batchSize != 0 || batchBytes != 0
+}
+
+// Send records in updates of at most batchSize records and batchBytes bytes
+// without splitting RRsets.
+func sendBatches(ctx context.Context, s updater.Updater, zone string, records []dns.RR, batchSize int, batchBytes int, logger *slog.Logger) int {
+	var ret int
+	for _, batch := range updater.Batch(dns.Fqdn(zone), records, batchSize, batchBytes) {
+		if ctx.Err() != nil {
+			break
 		}
+		ret += updateRecords(s, zone, batch, logger)
 	}
 	return ret
 }
 
-// if continueOnError is true, os.Exit(1) will be called.
-func insertRecords(s updater.Updater, zone string, records []dns.RR, logger *slog.Logger) int {
-	if err := s.Insert(dns.Fqdn(zone), records); err != nil {
-		logger.Error("Error inserting records", "err", err)
-		if *exitError {
-			os.Exit(1)
-		}
-		return 1
+// If exitError is true, os.Exit(1) will be called.
+func updateRecords(s updater.Updater, zone string, records []dns.RR, logger *slog.Logger) int {
+	if bisect {
+		return bisectRecords(s, zone, updater.RRsets(records), logger)
+	}
+	if err := s.Update(dns.Fqdn(zone), records); err != nil {
+		logger.Error("Error updating records", "err", err)
+		return handleError()
 	}
 	return 0
 }
 
-// Exit, limiting the code to a max of 125 (as recommended by os.Exit).
+// Send rrsets in one update. If the server rejects it, send each half
+// of rrsets the same way until the rejected RRsets are found.
+func bisectRecords(s updater.Updater, zone string, rrsets [][]dns.RR, logger *slog.Logger) int {
+	err := s.Update(dns.Fqdn(zone), slices.Concat(rrsets...))
+	if err == nil {
+		return 0
+	}
+	rcode, ok := bisectRcode(err)
+	switch {
+	case ok && len(rrsets) > 1:
+		logger.Debug("Update rejected, bisecting", "err", err, "rrsets", len(rrsets))
+		half := len(rrsets) / 2
+		return bisectRecords(s, zone, rrsets[:half], logger) + bisectRecords(s, zone, rrsets[half:], logger)
+	case ok && len(rrsets) == 1:
+		h := rrsets[0][0].Header()
+		logger.Error("RRset rejected", "name", h.Name, "type", dns.TypeToString[h.Rrtype], "rcode", dns.RcodeToString[rcode], "err", err)
+	default:
+		logger.Error("Error updating records", "err", err)
+	}
+	return handleError()
+}
+
+// Rcodes for which the server may have rejected only some of the RRsets in an update.
+var bisectRcodes = map[int]bool{
+	dns.RcodeFormatError: true,
+	dns.RcodeRefused:     true,
+	dns.RcodeYXDomain:    true,
+	dns.RcodeYXRrset:     true,
+	dns.RcodeNXRrset:     true,
+	dns.RcodeNotZone:     true,
+}
+
+// Returns the rcode of err and true if the update should be bisected.
+// Authentication and server failures would fail for every half.
+func bisectRcode(err error) (int, bool) {
+	var rerr *updater.ResponseError
+	if !errors.As(err, &rerr) || rerr.IsAuthError() {
+		return 0, false
+	}
+	return rerr.Rcode, bisectRcodes[rerr.Rcode]
+}
+
+// Exit if exitError is true. Otherwise, return 1 to be added to the error count.
+func handleError() int {
+	if exitError {
+		exit(1)
+	}
+	return 1
+}
+
+// Write the report and metrics if enabled and exit,
+// limiting the code to a max of 125 (as recommended by os.Exit).
 func exit(code int) {
+	code += writeOutputs(code)
 	if code > 125 {
 		code = 125
 	}
 	os.Exit(code)
 }
+
+// Write the report and metrics file if enabled. errs is the number of errors in the run.
+// Returns the number of files that couldn't be written.
+func writeOutputs(errs int) int {
+	var ret int
+	if report != nil {
+		if err := report.writeFile(reportFile, errs); err != nil {
+			slog.Error("Error writing the report", "err", err)
+			ret++
+		}
+	}
+	if metricsFile != "" {
+		if err := metrics.WriteFile(metricsFile); err != nil {
+			slog.Error("Error writing the metrics", "err", err)
+			ret++
+		}
+	}
+	return ret
+}
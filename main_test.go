@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/netip"
+	"reflect"
+	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/devon-mar/dnsupdater/config"
+	"github.com/devon-mar/dnsupdater/updater"
 
 	"github.com/miekg/dns"
 )
 
 type testUpdater struct {
+	mu         sync.Mutex
 	insertions map[string][][]dns.RR
 	allRecords []dns.RR
 }
@@ -27,8 +36,10 @@ func (*testUpdater) Close() error {
 	return nil
 }
 
-// Insert implements updater.Updater
-func (u *testUpdater) Insert(z string, rrSet []dns.RR) error {
+// Update implements updater.Updater
+func (u *testUpdater) Update(z string, rrSet []dns.RR) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 	u.init()
 	u.insertions[z] = append(u.insertions[z], rrSet)
 
@@ -131,11 +142,29 @@ func TestInsert(t *testing.T) {
 				},
 			},
 		},
+		"replace": {
+			zones: map[string]*config.Zone{
+				"example.com": {
+					Records: map[string]*config.Record{
+						"www": {
+							FQDN: "www",
+							Host: mustParseIPs("192.0.2.1"),
+							Mode: config.ModeReplace,
+						},
+					},
+				},
+			},
+			want: map[string][][]dns.RR{
+				"example.com.": {
+					append(updater.RemoveRRset([]dns.RR{testHost("www", "192.0.2.1")}), testHost("www", "192.0.2.1")),
+				},
+			},
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			u := &testUpdater{}
-			insert(u, tc.zones)
+			updateZones(context.Background(), u, tc.zones, recordUpdate)
 			u.assert(t, tc.want)
 		})
 	}
@@ -181,10 +210,10 @@ func TestInsertBatch(t *testing.T) {
 					},
 				},
 			},
+			// The RRset is not split.
 			want: map[string][][]dns.RR{
 				"example.com.": {
-					{testHost("www", "192.0.2.1")},
-					{testHost("www", "192.0.2.2")},
+					{testHost("www", "192.0.2.1"), testHost("www", "192.0.2.2")},
 					{testCNAME("www2", "www.example.com.")},
 				},
 			},
@@ -216,7 +245,7 @@ func TestInsertBatch(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			u := &testUpdater{}
-			insertBatch(u, tc.zones, tc.size)
+			updateZonesBatch(context.Background(), u, tc.zones, tc.size, 0, recordUpdate)
 			u.assert(t, tc.want)
 		})
 	}
@@ -276,9 +305,252 @@ func TestInsertBatch2(t *testing.T) {
 	for i := 1; i <= 12; i++ {
 		t.Run(fmt.Sprint(i), func(t *testing.T) {
 			u := &testUpdater{}
-			insertBatch(u, zones, i)
+			updateZonesBatch(context.Background(), u, zones, i, 0, recordUpdate)
 
 			assertRRSet(t, u.allRecords, wantRecords)
 		})
 	}
 }
+
+func TestRecordUpdate(t *testing.T) {
+	tests := map[string]struct {
+		r          *config.Record
+		wantUpdate []string
+		wantRemove []string
+	}{
+		"present": {
+			r:          &config.Record{FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 300},
+			wantUpdate: []string{"www.example.com.\t300\tIN\tA\t192.0.2.1"},
+			wantRemove: []string{"www.example.com.\t0\tNONE\tA\t192.0.2.1"},
+		},
+		"replace": {
+			r:          &config.Record{FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 300, Mode: config.ModeReplace},
+			wantUpdate: []string{"www.example.com.\t0\tCLASS255\tA\t", "www.example.com.\t300\tIN\tA\t192.0.2.1"},
+			wantRemove: []string{"www.example.com.\t0\tNONE\tA\t192.0.2.1"},
+		},
+		"absent name": {
+			r:          &config.Record{FQDN: "www.example.com.", State: config.StateAbsent},
+			wantUpdate: []string{"www.example.com.\t0\tCLASS255\tANY\t"},
+			wantRemove: []string{"www.example.com.\t0\tCLASS255\tANY\t"},
+		},
+		"absent rrsets": {
+			r:          &config.Record{FQDN: "www.example.com.", State: config.StateAbsent, Types: []string{"A", "txt"}},
+			wantUpdate: []string{"www.example.com.\t0\tCLASS255\tA\t", "www.example.com.\t0\tCLASS255\tTXT\t"},
+			wantRemove: []string{"www.example.com.\t0\tCLASS255\tA\t", "www.example.com.\t0\tCLASS255\tTXT\t"},
+		},
+		"absent rr": {
+			r:          &config.Record{FQDN: "www.example.com.", State: config.StateAbsent, Host: mustParseIPs("192.0.2.1")},
+			wantUpdate: []string{"www.example.com.\t0\tNONE\tA\t192.0.2.1"},
+			wantRemove: []string{"www.example.com.\t0\tNONE\tA\t192.0.2.1"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if have := rrStrings(recordUpdate(tc.r)); !reflect.DeepEqual(have, tc.wantUpdate) {
+				t.Errorf("recordUpdate: got %q, want %q", have, tc.wantUpdate)
+			}
+			if have := rrStrings(recordRemoval(tc.r)); !reflect.DeepEqual(have, tc.wantRemove) {
+				t.Errorf("recordRemoval: got %q, want %q", have, tc.wantRemove)
+			}
+		})
+	}
+}
+
+func TestForEachZone(t *testing.T) {
+	zones := map[string]*config.Zone{}
+	for i := 0; i < 10; i++ {
+		zones[fmt.Sprintf("example%d.com", i)] = &config.Zone{}
+	}
+
+	for _, p := range []int{0, 1, 3, 20} {
+		t.Run(fmt.Sprint(p), func(t *testing.T) {
+			parallel = p
+			defer func() { parallel = 0 }()
+
+			var running, maxRunning atomic.Int32
+			var mu sync.Mutex
+			seen := map[string]bool{}
+			have := forEachZone(context.Background(), zones, func(zoneName string, _ *config.Zone) int {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					m := maxRunning.Load()
+					if n <= m || maxRunning.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				seen[zoneName] = true
+				mu.Unlock()
+				return 1
+			})
+
+			if have != len(zones) {
+				t.Errorf("got %d errors, want %d", have, len(zones))
+			}
+			if len(seen) != len(zones) {
+				t.Errorf("got %d zones, want %d", len(seen), len(zones))
+			}
+			if m := int(maxRunning.Load()); m > max(p, 1) {
+				t.Errorf("got %d concurrent zones, want at most %d", m, max(p, 1))
+			}
+		})
+	}
+}
+
+func TestForEachZoneCanceled(t *testing.T) {
+	zones := map[string]*config.Zone{}
+	for i := 0; i < 10; i++ {
+		zones[fmt.Sprintf("example%d.com", i)] = &config.Zone{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	have := forEachZone(ctx, zones, func(string, *config.Zone) int {
+		cancel()
+		return 1
+	})
+	if have != 1 {
+		t.Errorf("got %d zones, want 1", have)
+	}
+}
+
+func TestUpdateParallel(t *testing.T) {
+	parallel = 4
+	defer func() { parallel = 0 }()
+
+	zones := map[string]*config.Zone{}
+	var want []dns.RR
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("www.example%d.com.", i)
+		zones[fmt.Sprintf("example%d.com", i)] = &config.Zone{
+			Records: map[string]*config.Record{"www": {FQDN: name, Host: mustParseIPs("192.0.2.1")}},
+		}
+		want = append(want, testHost(name, "192.0.2.1"))
+	}
+
+	u := &testUpdater{}
+	if have := update(context.Background(), u, zones, 0, 0, recordUpdate); have != 0 {
+		t.Errorf("got %d errors, want 0", have)
+	}
+	if have, want := rrStrings(u.allRecords), rrStrings(want); !reflect.DeepEqual(have, want) {
+		t.Errorf("got %v, want %v", have, want)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	base := updater.DefaultRetryPolicy()
+	c := &config.RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		Rcodes:         map[string]string{"servfail": config.RetryActionFail, "BADSIG": config.RetryActionRetry},
+		TransportError: config.RetryActionFailover,
+	}
+
+	want := updater.DefaultRetryPolicy()
+	want.MaxAttempts = 3
+	want.InitialBackoff = 500 * time.Millisecond
+	want.Rcodes[dns.RcodeServerFailure] = updater.ActionFail
+	want.Rcodes[dns.RcodeBadSig] = updater.ActionRetry
+	want.TransportError = updater.ActionFailover
+
+	if have := retryPolicy(base, c); !reflect.DeepEqual(have, want) {
+		t.Errorf("got %#v, want %#v", have, want)
+	}
+	if !reflect.DeepEqual(base, updater.DefaultRetryPolicy()) {
+		t.Errorf("base policy was modified: %#v", base)
+	}
+}
+
+// Refuses updates that contain a record owned by one of the names in refuse
+// with err, or REFUSED if it is nil.
+type refusingUpdater struct {
+	testUpdater
+	refuse  []string
+	err     *updater.ResponseError
+	updates int
+}
+
+// Update implements updater.Updater
+func (u *refusingUpdater) Update(z string, rrSet []dns.RR) error {
+	u.updates++
+	for _, rr := range rrSet {
+		if !slices.Contains(u.refuse, rr.Header().Name) {
+			continue
+		}
+		if u.err != nil {
+			return u.err
+		}
+		return &updater.ResponseError{Server: "ns.example.com", Zone: z, Rcode: dns.RcodeRefused}
+	}
+	return u.testUpdater.Update(z, rrSet)
+}
+
+func TestBisect(t *testing.T) {
+	bisect = true
+	defer func() { bisect = false }()
+
+	var records []dns.RR
+	for i := range 8 {
+		records = append(records, testHost(fmt.Sprintf("www%d.example.com.", i), "192.0.2.1"))
+	}
+	records = append(records, testHost("www3.example.com.", "192.0.2.2"))
+	var names []string
+	for _, rr := range records {
+		names = append(names, rr.Header().Name)
+	}
+
+	tests := map[string]struct {
+		refuse      []string
+		err         *updater.ResponseError
+		wantErrors  int
+		wantUpdates int
+	}{
+		"accepted": {wantUpdates: 1},
+		"one": {
+			refuse: []string{"www3.example.com."},
+			// 8 RRsets: 1 + 2 + 2 + 2
+			wantErrors:  1,
+			wantUpdates: 7,
+		},
+		"two": {
+			refuse:      []string{"www0.example.com.", "www7.example.com."},
+			wantErrors:  2,
+			wantUpdates: 11,
+		},
+		// Every half would fail the same way.
+		"auth": {
+			refuse:      names,
+			err:         &updater.ResponseError{Rcode: dns.RcodeNotAuth, TSIGError: dns.RcodeBadSig},
+			wantErrors:  1,
+			wantUpdates: 1,
+		},
+		"servfail": {
+			refuse:      names,
+			err:         &updater.ResponseError{Rcode: dns.RcodeServerFailure},
+			wantErrors:  1,
+			wantUpdates: 1,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := &refusingUpdater{refuse: tc.refuse, err: tc.err}
+			if have := sendBatches(context.Background(), u, "example.com", records, 0, 0, slog.Default()); have != tc.wantErrors {
+				t.Errorf("got %d errors, want %d", have, tc.wantErrors)
+			}
+			if u.updates != tc.wantUpdates {
+				t.Errorf("got %d updates, want %d", u.updates, tc.wantUpdates)
+			}
+			// Everything else is applied.
+			var want []dns.RR
+			for _, rr := range records {
+				if !slices.Contains(tc.refuse, rr.Header().Name) {
+					want = append(want, rr)
+				}
+			}
+			assertRRSet(t, u.allRecords, want)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+
+	"github.com/devon-mar/dnsupdater/config"
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	planNoChanges = 0
+	planError     = 1
+	planChanges   = 2
+)
+
+// Print the changes that insert (or sync if sync is true) would make to w.
+// Returns planError if a zone could not be transferred, planChanges
+// if there are changes pending and planNoChanges otherwise.
+func plan(t updater.Transferer, zones map[string]*config.Zone, sync bool, w io.Writer) int {
+	zoneNames := make([]string, 0, len(zones))
+	for name := range zones {
+		zoneNames = append(zoneNames, name)
+	}
+	sort.Strings(zoneNames)
+
+	ret := planNoChanges
+	for _, zoneName := range zoneNames {
+		logger := slog.With("zone", zoneName)
+		current, err := t.Transfer(dns.Fqdn(zoneName))
+		if err != nil {
+			logger.Error("Error transferring zone", "err", err)
+			ret = planError
+			continue
+		}
+
+		diffs := diffZone(dns.Fqdn(zoneName), zones[zoneName], current)
+		if printZonePlan(w, dns.Fqdn(zoneName), diffs, sync) && ret == planNoChanges {
+			ret = planChanges
+		}
+	}
+	return ret
+}
+
+// Print the plan for a single zone. Returns true if there are changes.
+func printZonePlan(w io.Writer, zoneName string, diffs map[string]*nameDiff, sync bool) bool {
+	names := make([]string, 0, len(diffs))
+	for name := range diffs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var add, remove, unchanged, conflict int
+	fmt.Fprintf(w, "zone %s\n", zoneName)
+	for _, name := range names {
+		d := diffs[name]
+		if !sync {
+			// insert only removes replaced RRsets and absent records.
+			d = &nameDiff{add: d.add, remove: d.insertRemove, unchanged: d.unchanged, conflict: d.conflict}
+		}
+		if len(d.add) == 0 && len(d.remove) == 0 && len(d.unchanged) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "  %s\n", name)
+		printRecords(w, "-", d.remove)
+		if !sync {
+			printRecords(w, "!", d.conflict)
+			conflict += len(d.conflict)
+		}
+		printRecords(w, "+", d.add)
+		printRecords(w, "=", d.unchanged)
+		add += len(d.add)
+		remove += len(d.remove)
+		unchanged += len(d.unchanged)
+	}
+	fmt.Fprintf(w, "%d to add, %d to remove, %d unchanged, %d conflicts\n\n", add, remove, unchanged, conflict)
+	return add > 0 || remove > 0
+}
+
+func printRecords(w io.Writer, prefix string, records []dns.RR) {
+	for _, rr := range records {
+		fmt.Fprintf(w, "    %s %s\n", prefix, rr)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/devon-mar/dnsupdater/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestPlan(t *testing.T) {
+	x := &testTransferer{zones: map[string][]dns.RR{"example.com.": testSyncZone}}
+
+	tests := map[string]struct {
+		zones map[string]*config.Zone
+		sync  bool
+		want  int
+		// Lines that must be in the output.
+		wantLines []string
+		// Lines that must not be in the output.
+		notLines []string
+	}{
+		"unchanged": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+				}},
+			},
+			want:      planNoChanges,
+			wantLines: []string{"= www.example.com.\t3600\tIN\tA\t192.0.2.1", "0 to add, 0 to remove, 1 unchanged, 0 conflicts"},
+			notLines:  []string{"- www.example.com.\t3600\tIN\tA\t192.0.2.9"},
+		},
+		"add": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.2"), TTL: 3600},
+				}},
+			},
+			want:      planChanges,
+			wantLines: []string{"+ www.example.com.\t3600\tIN\tA\t192.0.2.2", "1 to add, 0 to remove, 0 unchanged, 0 conflicts"},
+		},
+		"ttl": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 300},
+				}},
+			},
+			want:      planChanges,
+			wantLines: []string{"+ www.example.com.\t300\tIN\tA\t192.0.2.1"},
+		},
+		"conflict": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", CNAME: "web.example.com", TTL: 3600},
+				}},
+			},
+			want: planChanges,
+			wantLines: []string{
+				"! www.example.com.\t3600\tIN\tA\t192.0.2.1",
+				"+ www.example.com.\t3600\tIN\tCNAME\tweb.example.com.",
+				"1 to add, 0 to remove, 0 unchanged, 3 conflicts",
+			},
+		},
+		"replace": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600, Mode: config.ModeReplace},
+				}},
+			},
+			want: planChanges,
+			wantLines: []string{
+				"- www.example.com.\t3600\tIN\tA\t192.0.2.9",
+				"0 to add, 1 to remove, 1 unchanged, 0 conflicts",
+			},
+			notLines: []string{"TXT"},
+		},
+		"absent": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", TTL: 3600, State: config.StateAbsent, Types: []string{"TXT"}},
+				}},
+			},
+			want: planChanges,
+			wantLines: []string{
+				"- WWW.example.com.\t3600\tIN\tTXT\t\"old\"",
+				"0 to add, 1 to remove, 0 unchanged, 0 conflicts",
+			},
+		},
+		"sync": {
+			zones: map[string]*config.Zone{
+				"example.com": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+				}},
+			},
+			sync: true,
+			want: planChanges,
+			wantLines: []string{
+				"- www.example.com.\t3600\tIN\tA\t192.0.2.9",
+				"= www.example.com.\t3600\tIN\tA\t192.0.2.1",
+				"0 to add, 3 to remove, 1 unchanged, 0 conflicts",
+			},
+			notLines: []string{"SOA", "- example.com.\t3600\tIN\tNS\tns.example.com.", "sub.example.com."},
+		},
+		"transfer error": {
+			zones: map[string]*config.Zone{
+				"example.net": {Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.net.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+				}},
+			},
+			want: planError,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if have := plan(x, tc.zones, tc.sync, &buf); have != tc.want {
+				t.Errorf("got %d, want %d", have, tc.want)
+			}
+			out := buf.String()
+			for _, l := range tc.wantLines {
+				if !strings.Contains(out, l) {
+					t.Errorf("expected output to contain %q:\n%s", l, out)
+				}
+			}
+			for _, l := range tc.notLines {
+				if strings.Contains(out, l) {
+					t.Errorf("expected output to not contain %q:\n%s", l, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+// serverUpdater is implemented by updaters that can tell
+// which server accepted or rejected an update.
+type serverUpdater interface {
+	UpdateServer(zone string, records []dns.RR) (string, error)
+}
+
+// reporter records every update sent through it for the run report.
+type reporter struct {
+	updater.Updater
+	start time.Time
+	now   func() time.Time
+
+	mu sync.Mutex
+	// By canonical zone name.
+	zones map[string]*zoneReport
+}
+
+func newReporter(s updater.Updater) *reporter {
+	r := &reporter{Updater: s, now: time.Now, zones: map[string]*zoneReport{}}
+	r.start = r.now()
+	return r
+}
+
+// The JSON run report written by --report.
+type runReport struct {
+	Start    time.Time     `json:"start"`
+	Duration float64       `json:"duration_seconds"`
+	Totals   reportTotals  `json:"totals"`
+	Zones    []*zoneReport `json:"zones"`
+}
+
+type reportTotals struct {
+	Zones         int `json:"zones"`
+	Names         int `json:"names"`
+	Updates       int `json:"updates"`
+	FailedUpdates int `json:"failed_updates"`
+	// Records are counted once for every update that they were in.
+	Records       int `json:"records"`
+	FailedRecords int `json:"failed_records"`
+	// The number of errors, which unlike the exit code is not capped.
+	Errors int `json:"errors"`
+}
+
+type zoneReport struct {
+	Zone  string        `json:"zone"`
+	Names []*nameReport `json:"names"`
+
+	updates int
+	names   map[string]*nameReport
+}
+
+type nameReport struct {
+	Name     string           `json:"name"`
+	Attempts []*attemptReport `json:"attempts"`
+}
+
+// The records of a name in one update.
+type attemptReport struct {
+	// The number of the update within the zone, starting at 1.
+	Batch   int      `json:"batch"`
+	Records []string `json:"records"`
+	Server  string   `json:"server,omitempty"`
+	Rcode   string   `json:"rcode,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	// The time taken by the whole update.
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Update implements updater.Updater
+func (r *reporter) Update(zone string, records []dns.RR) error {
+	start := r.now()
+	var server string
+	var err error
+	if s, ok := r.Updater.(serverUpdater); ok {
+		server, err = s.UpdateServer(zone, records)
+	} else {
+		err = r.Updater.Update(zone, records)
+	}
+	duration := r.now().Sub(start)
+
+	var rcode string
+	var rerr *updater.ResponseError
+	if errors.As(err, &rerr) {
+		server = rerr.Server
+		rcode = dns.RcodeToString[rerr.Rcode]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	z, ok := r.zones[dns.CanonicalName(zone)]
+	if !ok {
+		z = &zoneReport{Zone: dns.CanonicalName(zone), names: map[string]*nameReport{}}
+		r.zones[z.Zone] = z
+	}
+	z.updates++
+
+	for _, rrset := range updater.RRsets(records) {
+		name := dns.CanonicalName(rrset[0].Header().Name)
+		n, ok := z.names[name]
+		if !ok {
+			n = &nameReport{Name: name}
+			z.names[name] = n
+			z.Names = append(z.Names, n)
+		}
+		// Records of the name in other RRsets are in the same attempt.
+		var a *attemptReport
+		if len(n.Attempts) > 0 && n.Attempts[len(n.Attempts)-1].Batch == z.updates {
+			a = n.Attempts[len(n.Attempts)-1]
+		} else {
+			a = &attemptReport{Batch: z.updates, Server: server, Rcode: rcode, Duration: duration.Seconds()}
+			if err != nil {
+				a.Error = err.Error()
+			}
+			n.Attempts = append(n.Attempts, a)
+		}
+		for _, rr := range rrset {
+			a.Records = append(a.Records, rr.String())
+		}
+	}
+	return err
+}
+
+// Returns the report of the updates so far. errs is the number of errors in the run.
+// r.mu must be held.
+func (r *reporter) report(errs int) *runReport {
+	rep := &runReport{
+		Start:    r.start,
+		Duration: r.now().Sub(r.start).Seconds(),
+		Zones:    make([]*zoneReport, 0, len(r.zones)),
+	}
+	rep.Totals.Errors = errs
+	for _, z := range r.zones {
+		rep.Zones = append(rep.Zones, z)
+		rep.Totals.Names += len(z.Names)
+		rep.Totals.Updates += z.updates
+		failed := map[int]bool{}
+		for _, n := range z.Names {
+			for _, a := range n.Attempts {
+				rep.Totals.Records += len(a.Records)
+				if a.Error != "" {
+					rep.Totals.FailedRecords += len(a.Records)
+					failed[a.Batch] = true
+				}
+			}
+		}
+		rep.Totals.FailedUpdates += len(failed)
+	}
+	rep.Totals.Zones = len(rep.Zones)
+	slices.SortFunc(rep.Zones, func(a, b *zoneReport) int { return strings.Compare(a.Zone, b.Zone) })
+	return rep
+}
+
+// Write the report as JSON to w.
+func (r *reporter) write(w io.Writer, errs int) error {
+	// Updates may still be running if exitError is true.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.report(errs))
+}
+
+// Write the report to the file path, or stdout if path is -.
+func (r *reporter) writeFile(path string, errs int) error {
+	if path == "-" {
+		return r.write(os.Stdout, errs)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.write(f, errs); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+// Accepts all updates and returns server as the server that accepted them.
+type testServerUpdater struct {
+	testUpdater
+	server string
+}
+
+// UpdateServer implements serverUpdater
+func (u *testServerUpdater) UpdateServer(zone string, records []dns.RR) (string, error) {
+	return u.server, u.Update(zone, records)
+}
+
+func TestReporter(t *testing.T) {
+	a1 := testHost("www.example.com.", "192.0.2.1")
+	a2 := testHost("www.example.com.", "192.0.2.2")
+	cname := testCNAME("web.example.com.", "www.example.com.")
+
+	tests := map[string]struct {
+		s    updater.Updater
+		want runReport
+	}{
+		"accepted": {
+			s: &testServerUpdater{server: "ns1.example.com:53"},
+			want: runReport{
+				Duration: 5,
+				Totals:   reportTotals{Zones: 1, Names: 2, Updates: 2, Records: 3},
+				Zones: []*zoneReport{{
+					Zone: "example.com.",
+					Names: []*nameReport{
+						{Name: "www.example.com.", Attempts: []*attemptReport{
+							{Batch: 1, Records: []string{a1.String(), a2.String()}, Server: "ns1.example.com:53", Duration: 1},
+						}},
+						{Name: "web.example.com.", Attempts: []*attemptReport{
+							{Batch: 2, Records: []string{cname.String()}, Server: "ns1.example.com:53", Duration: 1},
+						}},
+					},
+				}},
+			},
+		},
+		"refused": {
+			s: &refusingUpdater{refuse: []string{"web.example.com."}},
+			want: runReport{
+				Duration: 5,
+				Totals:   reportTotals{Zones: 1, Names: 2, Updates: 2, FailedUpdates: 1, Records: 3, FailedRecords: 1, Errors: 1},
+				Zones: []*zoneReport{{
+					Zone: "example.com.",
+					Names: []*nameReport{
+						{Name: "www.example.com.", Attempts: []*attemptReport{
+							{Batch: 1, Records: []string{a1.String(), a2.String()}, Duration: 1},
+						}},
+						{Name: "web.example.com.", Attempts: []*attemptReport{
+							{
+								Batch:    2,
+								Records:  []string{cname.String()},
+								Server:   "ns.example.com",
+								Rcode:    "REFUSED",
+								Error:    "rfc2136: ns.example.com: update Example.com.: got rcode REFUSED",
+								Duration: 1,
+							},
+						}},
+					},
+				}},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newReporter(tc.s)
+			// Every call to now advances the clock by a second.
+			var clock time.Time
+			r.start = clock
+			r.now = func() time.Time {
+				clock = clock.Add(time.Second)
+				return clock
+			}
+
+			_ = r.Update("example.com.", []dns.RR{a1, a2})
+			err := r.Update("Example.com.", []dns.RR{cname})
+			var errs int
+			if err != nil {
+				errs = 1
+			}
+
+			var b bytes.Buffer
+			if err := r.write(&b, errs); err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			var have runReport
+			if err := json.Unmarshal(b.Bytes(), &have); err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %s", b.String())
+			}
+		})
+	}
+}
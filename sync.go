@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/devon-mar/dnsupdater/config"
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+// Types that are maintained by the server and are never removed.
+var syncIgnoreTypes = map[uint16]bool{
+	dns.TypeSOA:        true,
+	dns.TypeRRSIG:      true,
+	dns.TypeNSEC:       true,
+	dns.TypeNSEC3:      true,
+	dns.TypeNSEC3PARAM: true,
+	dns.TypeDNSKEY:     true,
+	dns.TypeCDS:        true,
+	dns.TypeCDNSKEY:    true,
+}
+
+func syncZones(ctx context.Context, s updater.Updater, t updater.Transferer, zones map[string]*config.Zone, batchSize int, batchBytes int) int {
+	return forEachZone(ctx, zones, func(zoneName string, zone *config.Zone) int {
+		logger := slog.With("zone", zoneName)
+		logger.Info("Syncing records")
+
+		current, err := t.Transfer(dns.Fqdn(zoneName))
+		if err != nil {
+			logger.Error("Error transferring zone", "err", err)
+			return handleError()
+		}
+
+		updates := syncUpdates(dns.Fqdn(zoneName), zone, current)
+		// In a stable order so that the batches are the same on every run.
+		names := slices.Sorted(maps.Keys(updates))
+		if batching(batchSize, batchBytes) {
+			var queue []dns.RR
+			for _, name := range names {
+				queue = append(queue, updates[name]...)
+			}
+			return sendBatches(ctx, s, zoneName, queue, batchSize, batchBytes, logger) + waitZone(s, zoneName, logger)
+		}
+		var ret int
+		for _, name := range names {
+			if ctx.Err() != nil {
+				break
+			}
+			ret += updateRecords(s, zoneName, updates[name], logger.With("fqdn", name))
+		}
+		return ret + waitZone(s, zoneName, logger)
+	})
+}
+
+// Returns the update section for each name in the zone that has changes. Records in current
+// that are not in the config are removed before the missing records in the config are inserted.
+// zoneName should be a FQDN.
+func syncUpdates(zoneName string, zone *config.Zone, current []dns.RR) map[string][]dns.RR {
+	diffs := diffZone(zoneName, zone, current)
+	updates := make(map[string][]dns.RR, len(diffs))
+	for name, d := range diffs {
+		if len(d.add) == 0 && len(d.remove) == 0 {
+			continue
+		}
+		updates[name] = append(updater.Remove(d.remove), d.add...)
+	}
+	return updates
+}
+
+// Differences between the config and the zone for a single name.
+type nameDiff struct {
+	// Records in the config that are not in the zone.
+	add []dns.RR
+	// Records in the zone that are not in the config.
+	remove []dns.RR
+	// Records in both the config and the zone.
+	unchanged []dns.RR
+	// Records in remove that would prevent add from being inserted.
+	conflict []dns.RR
+	// Records in remove that insert also removes since they
+	// are in a replaced RRset or are absent in the config.
+	insertRemove []dns.RR
+}
+
+// Returns the differences between the config and current for each name.
+// A record whose TTL differs from the config is in add.
+// zoneName should be a FQDN.
+func diffZone(zoneName string, zone *config.Zone, current []dns.RR) map[string]*nameDiff {
+	want := make(map[string][]dns.RR, len(zone.Records))
+	replace := map[string]bool{}
+	absent := map[string]*config.Record{}
+	for _, r := range zone.Records {
+		name := dns.CanonicalName(r.FQDN)
+		if r.Absent() {
+			absent[name] = r
+			continue
+		}
+		want[name] = append(want[name], r.Records()...)
+		if r.Mode == config.ModeReplace {
+			replace[name] = true
+		}
+	}
+
+	cuts := delegations(zoneName, current)
+	have := map[string][]dns.RR{}
+	for _, rr := range current {
+		if syncManaged(zoneName, rr) && !belowDelegation(rr.Header().Name, cuts) {
+			name := dns.CanonicalName(rr.Header().Name)
+			have[name] = append(have[name], rr)
+		}
+	}
+
+	diffs := make(map[string]*nameDiff, len(want))
+	get := func(name string) *nameDiff {
+		d, ok := diffs[name]
+		if !ok {
+			d = &nameDiff{}
+			diffs[name] = d
+		}
+		return d
+	}
+	for name, records := range want {
+		d := get(name)
+		for _, rr := range records {
+			if containsRR(have[name], rr, true) {
+				d.unchanged = append(d.unchanged, rr)
+			} else {
+				d.add = append(d.add, rr)
+			}
+		}
+	}
+	for name, records := range have {
+		for _, rr := range records {
+			if containsRR(want[name], rr, false) {
+				continue
+			}
+			d := get(name)
+			d.remove = append(d.remove, rr)
+			if conflicts(want[name], rr) {
+				d.conflict = append(d.conflict, rr)
+			}
+			if (replace[name] && containsType(want[name], rr.Header().Rrtype)) || absentMatches(absent[name], rr) {
+				d.insertRemove = append(d.insertRemove, rr)
+			}
+		}
+	}
+	return diffs
+}
+
+// Returns true if rr can not exist alongside records.
+func conflicts(records []dns.RR, rr dns.RR) bool {
+	for _, r := range records {
+		if (r.Header().Rrtype == dns.TypeCNAME) != (rr.Header().Rrtype == dns.TypeCNAME) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if rr may be removed by sync.
+// The SOA and NS records at the apex are never removed.
+func syncManaged(zoneName string, rr dns.RR) bool {
+	h := rr.Header()
+	if h.Class != dns.ClassINET || syncIgnoreTypes[h.Rrtype] {
+		return false
+	}
+	return h.Rrtype != dns.TypeNS || dns.CanonicalName(h.Name) != dns.CanonicalName(zoneName)
+}
+
+// Returns the names below the apex that have NS records, which delegate child zones.
+func delegations(zoneName string, current []dns.RR) []string {
+	var ret []string
+	for _, rr := range current {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeNS && dns.CanonicalName(h.Name) != dns.CanonicalName(zoneName) {
+			ret = append(ret, h.Name)
+		}
+	}
+	return ret
+}
+
+// Returns true if name is at or below one of the delegations.
+// The NS records and glue of a delegation are never removed.
+func belowDelegation(name string, delegations []string) bool {
+	for _, d := range delegations {
+		if dns.IsSubDomain(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if rr is removed by the absent record r.
+func absentMatches(r *config.Record, rr dns.RR) bool {
+	if r == nil {
+		return false
+	}
+	records := r.Records()
+	if len(records) == 0 && len(r.Types) == 0 {
+		return true
+	}
+	return containsType(r.RRsets(), rr.Header().Rrtype) || containsRR(records, rr, false)
+}
+
+func containsType(records []dns.RR, rrtype uint16) bool {
+	for _, r := range records {
+		if r.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if records contains rr. The TTL is only compared if ttl is true.
+func containsRR(records []dns.RR, rr dns.RR, ttl bool) bool {
+	for _, r := range records {
+		if dns.IsDuplicate(r, rr) && (!ttl || r.Header().Ttl == rr.Header().Ttl) {
+			return true
+		}
+	}
+	return false
+}
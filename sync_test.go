@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/devon-mar/dnsupdater/config"
+
+	"github.com/miekg/dns"
+)
+
+type testTransferer struct {
+	zones map[string][]dns.RR
+}
+
+// Transfer implements updater.Transferer
+func (x *testTransferer) Transfer(zone string) ([]dns.RR, error) {
+	records, ok := x.zones[zone]
+	if !ok {
+		return nil, errors.New("zone not found")
+	}
+	return records, nil
+}
+
+func mustRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+func rrStrings(records []dns.RR) []string {
+	ret := make([]string, 0, len(records))
+	for _, rr := range records {
+		ret = append(ret, rr.String())
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+var testSyncZone = []dns.RR{
+	mustRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 3600"),
+	mustRR("example.com. 3600 IN NS ns.example.com."),
+	mustRR("ns.example.com. 3600 IN A 192.0.2.53"),
+	mustRR("sub.example.com. 3600 IN NS ns.example.net."),
+	mustRR("www.example.com. 3600 IN A 192.0.2.1"),
+	mustRR("www.example.com. 3600 IN A 192.0.2.9"),
+	mustRR("WWW.example.com. 3600 IN TXT \"old\""),
+}
+
+func TestSyncUpdates(t *testing.T) {
+	zone := &config.Zone{
+		Records: map[string]*config.Record{
+			"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+			"ns":  {FQDN: "ns.example.com.", Host: mustParseIPs("192.0.2.53"), TTL: 3600},
+			"new": {FQDN: "new.example.com.", CNAME: "www.example.com", TTL: 3600},
+		},
+	}
+
+	// Nothing is sent for ns.example.com. since it is unchanged.
+	want := map[string][]string{
+		"www.example.com.": rrStrings([]dns.RR{
+			mustRR("www.example.com. 0 NONE A 192.0.2.9"),
+			mustRR("WWW.example.com. 0 NONE TXT \"old\""),
+		}),
+		"new.example.com.": rrStrings([]dns.RR{
+			mustRR("new.example.com. 3600 IN CNAME www.example.com."),
+		}),
+	}
+
+	updates := syncUpdates("example.com.", zone, testSyncZone)
+	have := make(map[string][]string, len(updates))
+	for name, records := range updates {
+		have[name] = rrStrings(records)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("got %v, want %v", have, want)
+	}
+}
+
+func TestSyncUpdatesDelegation(t *testing.T) {
+	current := []dns.RR{
+		mustRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 3600"),
+		mustRR("example.com. 3600 IN NS ns.example.com."),
+		mustRR("sub.example.com. 3600 IN NS ns1.sub.example.com."),
+		mustRR("sub.example.com. 3600 IN DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"),
+		mustRR("ns1.sub.example.com. 3600 IN A 192.0.2.53"),
+		mustRR("deep.ns1.sub.example.com. 3600 IN AAAA 2001:db8::53"),
+		mustRR("notsub.example.com. 3600 IN A 192.0.2.2"),
+		mustRR("www.example.com. 3600 IN A 192.0.2.9"),
+	}
+	zone := &config.Zone{
+		Records: map[string]*config.Record{
+			"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+		},
+	}
+
+	// The delegation and everything below it is kept.
+	want := map[string][]string{
+		"www.example.com.": rrStrings([]dns.RR{
+			mustRR("www.example.com. 0 NONE A 192.0.2.9"),
+			mustRR("www.example.com. 3600 IN A 192.0.2.1"),
+		}),
+		"notsub.example.com.": rrStrings([]dns.RR{
+			mustRR("notsub.example.com. 0 NONE A 192.0.2.2"),
+		}),
+	}
+
+	updates := syncUpdates("example.com.", zone, current)
+	have := make(map[string][]string, len(updates))
+	for name, records := range updates {
+		have[name] = rrStrings(records)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("got %v, want %v", have, want)
+	}
+}
+
+func TestSyncUpdatesRemovalsFirst(t *testing.T) {
+	zone := &config.Zone{
+		Records: map[string]*config.Record{
+			"www": {FQDN: "www.example.com.", CNAME: "web.example.com", TTL: 3600},
+		},
+	}
+	updates := syncUpdates("example.com.", zone, testSyncZone)
+	records := updates["www.example.com."]
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4: %v", len(records), records)
+	}
+	if have := records[len(records)-1].Header().Rrtype; have != dns.TypeCNAME {
+		t.Errorf("expected the CNAME to be inserted last but got %s", dns.TypeToString[have])
+	}
+}
+
+func TestSync(t *testing.T) {
+	zones := map[string]*config.Zone{
+		"example.com": {
+			Records: map[string]*config.Record{
+				"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+			},
+		},
+		"example.net": {
+			Records: map[string]*config.Record{
+				"www": {FQDN: "www.example.net.", Host: mustParseIPs("192.0.2.1"), TTL: 3600},
+			},
+		},
+	}
+	x := &testTransferer{zones: map[string][]dns.RR{"example.com.": testSyncZone}}
+
+	tests := map[string]struct {
+		size        int
+		wantUpdates int
+	}{
+		"per name": {wantUpdates: 2},
+		// The RRset of two A records isn't split.
+		"size=1":  {size: 1, wantUpdates: 3},
+		"size=2":  {size: 2, wantUpdates: 2},
+		"size=10": {size: 10, wantUpdates: 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := &testUpdater{}
+			// example.net. can not be transferred.
+			if have := syncZones(context.Background(), u, x, zones, tc.size, 0); have != 1 {
+				t.Errorf("got %d errors, want 1", have)
+			}
+			if have := len(u.insertions["example.com."]); have != tc.wantUpdates {
+				t.Errorf("got %d updates, want %d", have, tc.wantUpdates)
+			}
+			// The unchanged A record isn't sent.
+			if have := len(u.allRecords); have != 3 {
+				t.Errorf("got %d records, want 3: %v", have, u.allRecords)
+			}
+			// Names are sent in order.
+			if !slices.IsSortedFunc(u.allRecords, func(a, b dns.RR) int {
+				return strings.Compare(dns.CanonicalName(a.Header().Name), dns.CanonicalName(b.Header().Name))
+			}) {
+				t.Errorf("expected the records to be sorted by name: %v", u.allRecords)
+			}
+		})
+	}
+}
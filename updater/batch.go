@@ -0,0 +1,64 @@
+package updater
+
+import "github.com/miekg/dns"
+
+// Bytes left free in each batch for the TSIG, GSS-TSIG or SIG(0) record
+// that is added when the update is signed.
+const signatureReserve = 1024
+
+// MinBatchSize is the smallest maximum size of Batch that leaves room
+// for the message header, the signature and at least one byte of records.
+const MinBatchSize = signatureReserve + 12 + 1
+
+// Batch splits records into the update sections of UPDATE messages for zone
+// with at most maxRecords records and at most maxSize bytes each, including
+// room for a signature. A limit of 0 means no record limit and dns.MaxMsgSize.
+//
+// The records of an RRset are always in the same batch, even if that exceeds
+// the limits. RRsets are ordered by their first record so that deletions
+// are still sent before the insertions that follow them.
+func Batch(zone string, records []dns.RR, maxRecords int, maxSize int) [][]dns.RR {
+	if maxSize == 0 {
+		maxSize = dns.MaxMsgSize
+	}
+	maxSize -= signatureReserve
+
+	// The size of the message without an update section.
+	base := newUpdate(zone, nil).Len()
+	header := new(dns.Msg).Len()
+	var batches [][]dns.RR
+	var batch []dns.RR
+	size := base
+	for _, rrset := range RRsets(records) {
+		// Updates aren't compressed so the sizes of the records add up.
+		n := (&dns.Msg{Ns: rrset}).Len() - header
+		full := maxRecords > 0 && len(batch)+len(rrset) > maxRecords || size+n > maxSize
+		if len(batch) > 0 && full {
+			batches = append(batches, batch)
+			batch, size = nil, base
+		}
+		batch = append(batch, rrset...)
+		size += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// RRsets groups records by RRset in the order of the first record of each RRset.
+func RRsets(records []dns.RR) [][]dns.RR {
+	var rrsets [][]dns.RR
+	index := map[rrsetKey]int{}
+	for _, rr := range records {
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		i, ok := index[key]
+		if !ok {
+			i = len(rrsets)
+			index[key] = i
+			rrsets = append(rrsets, nil)
+		}
+		rrsets[i] = append(rrsets[i], rr)
+	}
+	return rrsets
+}
@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBatch(t *testing.T) {
+	a1 := mustRR("www.example.com. 300 IN A 192.0.2.1")
+	a2 := mustRR("WWW.example.com. 300 IN A 192.0.2.2")
+	aaaa := mustRR("www.example.com. 300 IN AAAA 2001:db8::1")
+	cname := mustRR("web.example.com. 300 IN CNAME www.example.com.")
+	removeA := RemoveRRset([]dns.RR{a1})[0]
+
+	tests := map[string]struct {
+		records    []dns.RR
+		maxRecords int
+		maxSize    int
+		want       [][]dns.RR
+	}{
+		"no limit": {
+			records: []dns.RR{a1, aaaa, cname},
+			want:    [][]dns.RR{{a1, aaaa, cname}},
+		},
+		"records": {
+			records:    []dns.RR{a1, aaaa, cname},
+			maxRecords: 2,
+			want:       [][]dns.RR{{a1, aaaa}, {cname}},
+		},
+		"rrset not split": {
+			records:    []dns.RR{a1, a2, cname},
+			maxRecords: 1,
+			want:       [][]dns.RR{{a1, a2}, {cname}},
+		},
+		"rrset grouped": {
+			records:    []dns.RR{removeA, aaaa, a1, a2},
+			maxRecords: 2,
+			want:       [][]dns.RR{{removeA, a1, a2}, {aaaa}},
+		},
+		"size": {
+			records: []dns.RR{a1, aaaa, cname},
+			// The header, zone, OPT, signature reserve and the A and AAAA records.
+			maxSize: 12 + 17 + 11 + signatureReserve + dns.Len(a1) + dns.Len(aaaa),
+			want:    [][]dns.RR{{a1, aaaa}, {cname}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			have := Batch(testZone, tc.records, tc.maxRecords, tc.maxSize)
+			if !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got %v, want %v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	// Long TXT records that don't fit in one message.
+	var records []dns.RR
+	for i := range 100 {
+		records = append(records, mustRR(fmt.Sprintf(`txt%d.example.com. 300 IN TXT "%s"`, i, strings.Repeat("a", 255)+`" "`+strings.Repeat("b", 255)+`" "`+strings.Repeat("c", 255))))
+	}
+
+	batches := Batch(testZone, records, 0, 0)
+	if len(batches) < 2 {
+		t.Fatalf("got %d batches, want at least 2", len(batches))
+	}
+	var n int
+	for _, b := range batches {
+		n += len(b)
+		msg := newUpdate(testZone, b)
+		if l := msg.Len(); l > dns.MaxMsgSize-signatureReserve {
+			t.Errorf("got a message of %d bytes", l)
+		}
+		if _, err := msg.Pack(); err != nil {
+			t.Errorf("expected no error packing the message but got: %v", err)
+		}
+	}
+	if n != len(records) {
+		t.Errorf("got %d records, want %d", n, len(records))
+	}
+}
@@ -0,0 +1,285 @@
+package updater
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The default timeout for a response, like dns.Client.
+const connTimeout = 2 * time.Second
+
+var errConnClosed = errors.New("connection closed")
+
+// connPool exchanges messages over a persistent TCP connection per server.
+// Failed connections are replaced on the next exchange.
+// It is safe for concurrent use.
+type connPool struct {
+	// Used to dial connections and for its timeouts and TSIG settings.
+	client *dns.Client
+	// The maximum number of outstanding requests per connection.
+	// Values less than 1 mean unlimited.
+	maxInflight int
+
+	mu     sync.Mutex
+	conns  map[string]*pipeConn
+	closed bool
+}
+
+func newConnPool(client *dns.Client) *connPool {
+	return &connPool{client: client, maxInflight: 1}
+}
+
+// Exchange implements dnsExchanger
+func (p *connPool) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	return p.ExchangeTimeout(msg, server, p.timeout())
+}
+
+// ExchangeTimeout implements timeoutExchanger
+func (p *connPool) ExchangeTimeout(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, time.Duration, error) {
+	timeout = min(timeout, p.timeout())
+	start := time.Now()
+	c, reused, err := p.get(server, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := c.exchange(msg, timeout)
+	// The server may have closed an idle connection,
+	// so try again once on a new connection.
+	if reused && errors.Is(err, errConnClosed) {
+		if c, _, err = p.get(server, timeout); err != nil {
+			return nil, 0, err
+		}
+		r, err = c.exchange(msg, timeout)
+	}
+	return r, time.Since(start), err
+}
+
+// Returns the connection to server, dialing a new one if there is none
+// or the old one failed, waiting at most timeout to connect.
+// reused is true if the connection was used before.
+func (p *connPool) get(server string, timeout time.Duration) (c *pipeConn, reused bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, false, errConnClosed
+	}
+	if c, ok := p.conns[server]; ok && c.alive() {
+		return c, true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := p.client.DialContext(ctx, server)
+	if err != nil {
+		return nil, false, err
+	}
+	c = newPipeConn(conn, p.client, p.maxInflight)
+	if p.conns == nil {
+		p.conns = map[string]*pipeConn{}
+	}
+	p.conns[server] = c
+	return c, false, nil
+}
+
+func (p *connPool) timeout() time.Duration {
+	if p.client.Timeout != 0 {
+		return p.client.Timeout
+	}
+	if p.client.ReadTimeout != 0 {
+		return p.client.ReadTimeout
+	}
+	return connTimeout
+}
+
+// Close closes all connections.
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var errs []error
+	for server, c := range p.conns {
+		errs = append(errs, c.close(errConnClosed))
+		delete(p.conns, server)
+	}
+	return errors.Join(errs...)
+}
+
+type result struct {
+	msg *dns.Msg
+	err error
+}
+
+type call struct {
+	// The MAC of the request for verifying the response's TSIG.
+	mac  string
+	done chan result
+}
+
+// pipeConn sends requests over a single connection without waiting
+// for the responses to earlier requests (RFC 7766 section 6.2.1.1).
+// Responses are matched to requests by their ID.
+type pipeConn struct {
+	conn   *dns.Conn
+	client *dns.Client
+	// Limits the number of outstanding requests. nil if unlimited.
+	inflight chan struct{}
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]*call
+	// Set once the connection failed.
+	err error
+}
+
+func newPipeConn(conn *dns.Conn, client *dns.Client, maxInflight int) *pipeConn {
+	c := &pipeConn{
+		conn:    conn,
+		client:  client,
+		pending: map[uint16]*call{},
+	}
+	if maxInflight > 0 {
+		c.inflight = make(chan struct{}, maxInflight)
+	}
+	go c.read()
+	return c
+}
+
+func (c *pipeConn) alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+// Send msg and wait for its response.
+func (c *pipeConn) exchange(msg *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	if c.inflight != nil {
+		c.inflight <- struct{}{}
+		defer func() { <-c.inflight }()
+	}
+
+	buf, mac, err := c.pack(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &call{mac: mac, done: make(chan result, 1)}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	if _, ok := c.pending[msg.Id]; ok {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("a request with ID %d is already in progress", msg.Id)
+	}
+	c.pending[msg.Id] = cl
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = c.conn.Write(buf)
+	c.writeMu.Unlock()
+	if err != nil {
+		_ = c.close(fmt.Errorf("%w: %w", errConnClosed, err))
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-cl.done:
+		return r.msg, r.err
+	case <-t.C:
+		// The connection can't be trusted anymore.
+		_ = c.close(os.ErrDeadlineExceeded)
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Returns msg in wire format, signing it if it has a TSIG record.
+func (c *pipeConn) pack(msg *dns.Msg) ([]byte, string, error) {
+	t := msg.IsTsig()
+	if t == nil {
+		b, err := msg.Pack()
+		return b, "", err
+	}
+	if c.client.TsigProvider != nil {
+		return dns.TsigGenerateWithProvider(msg, c.client.TsigProvider, "", false)
+	}
+	secret, ok := c.client.TsigSecret[t.Hdr.Name]
+	if !ok {
+		return nil, "", dns.ErrSecret
+	}
+	return dns.TsigGenerate(msg, secret, "", false)
+}
+
+// Verify the TSIG of the response b to a request with MAC mac.
+func (c *pipeConn) verify(b []byte, t *dns.TSIG, mac string) error {
+	if c.client.TsigProvider != nil {
+		return dns.TsigVerifyWithProvider(b, c.client.TsigProvider, mac, false)
+	}
+	secret, ok := c.client.TsigSecret[t.Hdr.Name]
+	if !ok {
+		return dns.ErrSecret
+	}
+	return dns.TsigVerify(b, secret, mac, false)
+}
+
+// Read responses until the connection fails.
+func (c *pipeConn) read() {
+	for {
+		b := make([]byte, dns.MaxMsgSize)
+		n, err := c.conn.Read(b)
+		if err != nil {
+			_ = c.close(fmt.Errorf("%w: %w", errConnClosed, err))
+			return
+		}
+		b = b[:n]
+		if len(b) < 2 {
+			continue
+		}
+
+		id := binary.BigEndian.Uint16(b)
+		c.mu.Lock()
+		cl, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if !ok {
+			// The request timed out, or the response is bogus.
+			continue
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(b); err != nil {
+			cl.done <- result{err: err}
+			continue
+		}
+		if t := m.IsTsig(); t != nil {
+			err = c.verify(b, t, cl.mac)
+		}
+		cl.done <- result{msg: m, err: err}
+	}
+}
+
+// Close the connection, failing all outstanding requests with err.
+// Only the first call has an effect.
+func (c *pipeConn) close(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil
+	}
+	c.err = err
+	for id, cl := range c.pending {
+		cl.done <- result{err: err}
+		delete(c.pending, id)
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,211 @@
+package updater
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGName   = "key.example.com."
+	testTSIGSecret = "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI="
+
+	// Names handled specially by connHandler.
+	closeName   = "close.example.com."
+	timeoutName = "timeout.example.com."
+)
+
+type countingListener struct {
+	net.Listener
+	accepts atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepts.Add(1)
+	}
+	return c, err
+}
+
+// Starts a server with tsigHandler that counts the accepted connections.
+func startConnServer(tb testing.TB) (string, *countingListener) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("error listening: %v", err)
+	}
+	cl := &countingListener{Listener: l}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          cl,
+		TsigSecret:        map[string]string{testTSIGName: testTSIGSecret},
+		NotifyStartedFunc: func() { close(started) },
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler:           dns.HandlerFunc(connHandler),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	tb.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+	return l.Addr().String(), cl
+}
+
+// Like tsigHandler, but closes the connection after responding to closeName
+// and doesn't respond to timeoutName.
+func connHandler(w dns.ResponseWriter, r *dns.Msg) {
+	switch r.Question[0].Name {
+	case timeoutName:
+		return
+	case closeName:
+		defer w.Close()
+	}
+	tsigHandler(w, r)
+}
+
+func newTestConnPool() *connPool {
+	return newConnPool(&dns.Client{
+		Net:        "tcp",
+		Timeout:    time.Second,
+		TsigSecret: map[string]string{testTSIGName: testTSIGSecret},
+	})
+}
+
+func newTestQuery(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeSOA)
+	m.SetTsig(testTSIGName, dns.HmacSHA256, 300, time.Now().Unix())
+	return m
+}
+
+func exchangeOK(t *testing.T, p *connPool, server string, name string) {
+	t.Helper()
+	r, _, err := p.Exchange(newTestQuery(name), server)
+	if err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	} else if r.Rcode != dns.RcodeSuccess {
+		t.Errorf("got rcode %s, want NOERROR", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	server, l := startConnServer(t)
+	p := newTestConnPool()
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		exchangeOK(t, p, server, "example.com.")
+	}
+	if have := l.accepts.Load(); have != 1 {
+		t.Errorf("got %d connections, want 1", have)
+	}
+}
+
+func TestConnPoolReconnect(t *testing.T) {
+	server, l := startConnServer(t)
+	p := newTestConnPool()
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		exchangeOK(t, p, server, closeName)
+	}
+	if have := l.accepts.Load(); have != 3 {
+		t.Errorf("got %d connections, want 3", have)
+	}
+}
+
+func TestConnPoolTimeout(t *testing.T) {
+	server, l := startConnServer(t)
+	p := newTestConnPool()
+	p.client.Timeout = 100 * time.Millisecond
+	defer p.Close()
+
+	_, _, err := p.Exchange(newTestQuery(timeoutName), server)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if a := DefaultRetryPolicy().action(err); a != ActionRetry {
+		t.Errorf("got action %s, want %s", a, ActionRetry)
+	}
+
+	exchangeOK(t, p, server, "example.com.")
+	if have := l.accepts.Load(); have != 2 {
+		t.Errorf("got %d connections, want 2", have)
+	}
+}
+
+func TestConnPoolPipelining(t *testing.T) {
+	for name, maxInflight := range map[string]int{"sequential": 1, "pipelined": 0} {
+		t.Run(name, func(t *testing.T) {
+			server, l := startConnServer(t)
+			p := newTestConnPool()
+			p.maxInflight = maxInflight
+			defer p.Close()
+
+			// Make sure that all requests use the same connection.
+			exchangeOK(t, p, server, "example.com.")
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					exchangeOK(t, p, server, "example.com.")
+				}()
+			}
+			wg.Wait()
+
+			if have := l.accepts.Load(); have != 1 {
+				t.Errorf("got %d connections, want 1", have)
+			}
+		})
+	}
+}
+
+func TestConnPoolClose(t *testing.T) {
+	server, _ := startConnServer(t)
+	p := newTestConnPool()
+	exchangeOK(t, p, server, "example.com.")
+	if err := p.Close(); err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+	if _, _, err := p.Exchange(newTestQuery("example.com."), server); !errors.Is(err, errConnClosed) {
+		t.Errorf("got error %v, want %v", err, errConnClosed)
+	}
+}
+
+func BenchmarkExchange(b *testing.B) {
+	server, _ := startConnServer(b)
+	client := &dns.Client{Net: "tcp", TsigSecret: map[string]string{testTSIGName: testTSIGSecret}}
+
+	tests := map[string]func() dnsExchanger{
+		"client": func() dnsExchanger { return client },
+		"pool":   func() dnsExchanger { return newConnPool(client) },
+		"pipelined": func() dnsExchanger {
+			p := newConnPool(client)
+			p.maxInflight = 0
+			return p
+		},
+	}
+	for name, newExchanger := range tests {
+		b.Run(name, func(b *testing.B) {
+			d := newExchanger()
+			if p, ok := d.(*connPool); ok {
+				defer p.Close()
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := d.Exchange(newTestQuery("example.com."), server); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
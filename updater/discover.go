@@ -0,0 +1,161 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// Returns the nameservers in resolv.conf.
+func systemResolvers() ([]string, error) {
+	c, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(c.Servers))
+	for _, s := range c.Servers {
+		servers = append(servers, net.JoinHostPort(s, c.Port))
+	}
+	return servers, nil
+}
+
+// WithResolvers sets the recursive resolvers used to discover
+// the servers for a zone. The default is the nameservers in resolv.conf.
+func (u *RFC2136Updater) WithResolvers(resolvers []string) {
+	u.resolvers = func() ([]string, error) { return resolvers, nil }
+}
+
+// Returns the servers to send requests for zone to.
+// If no servers were configured they are discovered
+// using discoverServers and cached.
+func (u *RFC2136Updater) zoneServers(zone string) ([]string, error) {
+	if len(u.servers) > 0 {
+		return u.servers, nil
+	}
+	zone = dns.CanonicalName(zone)
+
+	u.discoveredMu.Lock()
+	servers, ok := u.discovered[zone]
+	u.discoveredMu.Unlock()
+	if ok {
+		return servers, nil
+	}
+
+	servers, err := u.discoverServers(zone)
+	if err != nil {
+		return nil, fmt.Errorf("discover servers for %s: %w", zone, err)
+	}
+
+	u.discoveredMu.Lock()
+	defer u.discoveredMu.Unlock()
+	if u.discovered == nil {
+		u.discovered = map[string][]string{}
+	}
+	u.discovered[zone] = servers
+	return servers, nil
+}
+
+// Returns the MNAME from the zone's SOA followed by the rest of the zone's NS records,
+// like nsupdate does when no server is given.
+func (u *RFC2136Updater) discoverServers(zone string) ([]string, error) {
+	soa, err := u.lookup(zone, dns.TypeSOA)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := u.lookup(zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, rr := range soa {
+		if rr, ok := rr.(*dns.SOA); ok {
+			names = append(names, dns.CanonicalName(rr.Ns))
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no SOA record")
+	}
+	for _, rr := range ns {
+		if rr, ok := rr.(*dns.NS); ok && !slices.Contains(names, dns.CanonicalName(rr.Ns)) {
+			names = append(names, dns.CanonicalName(rr.Ns))
+		}
+	}
+
+	servers := make([]string, 0, len(names))
+	for _, n := range names {
+		servers = append(servers, net.JoinHostPort(strings.TrimSuffix(n, "."), "53"))
+	}
+	return servers, nil
+}
+
+// FindZone returns the zone that contains name using the owner of the SOA record
+// in the resolvers' response to an SOA query for name.
+func (u *RFC2136Updater) FindZone(name string) (string, error) {
+	name = dns.Fqdn(name)
+	resp, server, err := u.resolve(name, dns.TypeSOA)
+	if err != nil {
+		return "", fmt.Errorf("find zone of %s: %w", name, err)
+	}
+	// The SOA is in the answer section for an apex and in the authority
+	// section for other names, including names that don't exist.
+	for _, rr := range slices.Concat(resp.Answer, resp.Ns) {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+			return dns.CanonicalName(soa.Hdr.Name), nil
+		}
+	}
+	return "", fmt.Errorf("find zone of %s: %s: no SOA record", name, server)
+}
+
+// Returns the records of type t owned by name using the first resolver that answers.
+func (u *RFC2136Updater) lookup(name string, t uint16) ([]dns.RR, error) {
+	resp, server, err := u.resolve(name, t)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s: %s %s: got rcode %s", server, name, dns.TypeToString[t], dns.RcodeToString[resp.Rcode])
+	}
+	var records []dns.RR
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == t && strings.EqualFold(rr.Header().Name, name) {
+			records = append(records, rr)
+		}
+	}
+	return records, nil
+}
+
+// Sends a query for name and t to the first resolver that answers
+// and returns the response and the resolver.
+// The response's rcode is either NOERROR or NXDOMAIN.
+func (u *RFC2136Updater) resolve(name string, t uint16) (*dns.Msg, string, error) {
+	resolvers, err := u.resolvers()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(resolvers) == 0 {
+		return nil, "", errors.New("no resolvers")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, t)
+	for _, r := range resolvers {
+		var resp *dns.Msg
+		resp, err = u.exchange(msg, r)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", r, err)
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			return nil, "", fmt.Errorf("%s: %s %s: got rcode %s", r, name, dns.TypeToString[t], dns.RcodeToString[resp.Rcode])
+		}
+		return resp, r, nil
+	}
+	return nil, "", err
+}
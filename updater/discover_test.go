@@ -0,0 +1,186 @@
+package updater
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testResolver = "192.0.2.53:53"
+
+// Answers queries from records and accepts all updates.
+type discoverDNS struct {
+	records []dns.RR
+	fail    bool
+	// The number of queries by type.
+	queries map[uint16]int
+	// The servers that updates were sent to.
+	updates []string
+}
+
+// Exchange implements dnsExchanger
+func (d *discoverDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	if msg.Opcode == dns.OpcodeUpdate {
+		d.updates = append(d.updates, server)
+		return new(dns.Msg).SetReply(msg), time.Millisecond, nil
+	}
+	if server != testResolver {
+		return nil, 0, errors.New("query sent to " + server)
+	}
+	if d.fail {
+		return nil, 0, errors.New("timeout")
+	}
+	if d.queries == nil {
+		d.queries = map[uint16]int{}
+	}
+	q := msg.Question[0]
+	d.queries[q.Qtype]++
+
+	r := new(dns.Msg).SetReply(msg)
+	for _, rr := range d.records {
+		if rr.Header().Rrtype == q.Qtype && rr.Header().Name == q.Name {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestDiscoverServers(t *testing.T) {
+	soa := mustRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 3600")
+	ns1 := mustRR("example.com. 3600 IN NS NS1.example.com.")
+	ns2 := mustRR("example.com. 3600 IN NS ns2.example.com.")
+
+	tests := map[string]struct {
+		records   []dns.RR
+		fail      bool
+		want      []string
+		wantError bool
+	}{
+		"soa and ns": {
+			records: []dns.RR{soa, ns1, ns2},
+			want:    []string{"ns1.example.com:53", "ns2.example.com:53"},
+		},
+		"soa only": {
+			records: []dns.RR{soa},
+			want:    []string{"ns1.example.com:53"},
+		},
+		"no soa": {
+			records:   []dns.RR{ns1, ns2},
+			wantError: true,
+		},
+		"resolver error": {
+			fail:      true,
+			wantError: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &discoverDNS{records: tc.records, fail: tc.fail}
+			u := &RFC2136Updater{dns: d}
+			u.WithResolvers([]string{testResolver})
+
+			err := u.Update("example.com.", nil)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(d.updates, tc.want[:1]) {
+				t.Errorf("got updates sent to %v, want %v", d.updates, tc.want[:1])
+			}
+			if have := u.discovered["example.com."]; !reflect.DeepEqual(have, tc.want) {
+				t.Errorf("got servers %v, want %v", have, tc.want)
+			}
+
+			// The servers should be cached.
+			if err := u.Update("EXAMPLE.com.", nil); err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if want := map[uint16]int{dns.TypeSOA: 1, dns.TypeNS: 1}; !reflect.DeepEqual(d.queries, want) {
+				t.Errorf("got queries %v, want %v", d.queries, want)
+			}
+		})
+	}
+}
+
+func TestDiscoverServersConfigured(t *testing.T) {
+	d := &discoverDNS{}
+	u := &RFC2136Updater{dns: d, servers: []string{testNS1}}
+	u.WithResolvers([]string{testResolver})
+	if err := u.Update(testZone, nil); err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+	if len(d.queries) != 0 {
+		t.Errorf("expected no queries but got %v", d.queries)
+	}
+	if !reflect.DeepEqual(d.updates, []string{testNS1}) {
+		t.Errorf("got updates sent to %v, want %v", d.updates, []string{testNS1})
+	}
+}
+
+// Answers SOA queries like a resolver for the zones in soas.
+type findZoneDNS struct {
+	soas []dns.RR
+}
+
+// Exchange implements dnsExchanger
+func (d *findZoneDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	r := new(dns.Msg).SetReply(msg)
+	q := msg.Question[0]
+	var zone dns.RR
+	for _, soa := range d.soas {
+		if dns.IsSubDomain(soa.Header().Name, q.Name) && (zone == nil || len(soa.Header().Name) > len(zone.Header().Name)) {
+			zone = soa
+		}
+	}
+	switch {
+	case zone == nil:
+		r.Rcode = dns.RcodeServerFailure
+	case zone.Header().Name == q.Name:
+		r.Answer = append(r.Answer, zone)
+	default:
+		r.Rcode = dns.RcodeNameError
+		r.Ns = append(r.Ns, zone)
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestFindZone(t *testing.T) {
+	soas := []dns.RR{
+		mustRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 3600"),
+		mustRR("sub.example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 3600"),
+	}
+
+	tests := map[string]struct {
+		want      string
+		wantError bool
+	}{
+		"www.example.com":      {want: "example.com."},
+		"example.com.":         {want: "example.com."},
+		"a.b.Sub.example.com.": {want: "sub.example.com."},
+		"sub.example.com":      {want: "sub.example.com."},
+		"example.org.":         {wantError: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := &RFC2136Updater{dns: &findZoneDNS{soas: soas}}
+			u.WithResolvers([]string{testResolver})
+
+			have, err := u.FindZone(name)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if have != tc.want {
+				t.Errorf("got %q, want %q", have, tc.want)
+			}
+		})
+	}
+}
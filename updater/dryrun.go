@@ -0,0 +1,82 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DryRunUpdater prints the UPDATE messages in the format
+// used by nsupdate instead of sending them.
+// It is safe for concurrent use.
+type DryRunUpdater struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewDryRun(w io.Writer) *DryRunUpdater {
+	return &DryRunUpdater{w: w}
+}
+
+func (u *DryRunUpdater) Close() error {
+	return nil
+}
+
+func (u *DryRunUpdater) Update(zone string, records []dns.RR) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, err := io.WriteString(u.w, formatUpdate(newUpdate(zone, records)))
+	return err
+}
+
+// Returns msg as nsupdate commands.
+func formatUpdate(msg *dns.Msg) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "zone %s\n", msg.Question[0].Name)
+	for _, rr := range msg.Answer {
+		fmt.Fprintf(&b, "prereq %s\n", formatPrereq(rr))
+	}
+	for _, rr := range msg.Ns {
+		fmt.Fprintf(&b, "update %s\n", formatUpdateRR(rr))
+	}
+	b.WriteString("send\n")
+	return b.String()
+}
+
+// Returns the rdata of rr in presentation format.
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// RFC 2136 section 2.4.
+func formatPrereq(rr dns.RR) string {
+	h := rr.Header()
+	switch {
+	case h.Class == dns.ClassANY && h.Rrtype == dns.TypeANY:
+		return "yxdomain " + h.Name
+	case h.Class == dns.ClassNONE && h.Rrtype == dns.TypeANY:
+		return "nxdomain " + h.Name
+	case h.Class == dns.ClassANY:
+		return fmt.Sprintf("yxrrset %s %s", h.Name, dns.TypeToString[h.Rrtype])
+	case h.Class == dns.ClassNONE:
+		return fmt.Sprintf("nxrrset %s %s", h.Name, dns.TypeToString[h.Rrtype])
+	}
+	return fmt.Sprintf("yxrrset %s %s %s", h.Name, dns.TypeToString[h.Rrtype], rdata(rr))
+}
+
+// RFC 2136 section 2.5.
+func formatUpdateRR(rr dns.RR) string {
+	h := rr.Header()
+	switch {
+	case h.Class == dns.ClassANY && h.Rrtype == dns.TypeANY:
+		return "delete " + h.Name
+	case h.Class == dns.ClassANY:
+		return fmt.Sprintf("delete %s %s", h.Name, dns.TypeToString[h.Rrtype])
+	case h.Class == dns.ClassNONE:
+		return fmt.Sprintf("delete %s %s %s", h.Name, dns.TypeToString[h.Rrtype], rdata(rr))
+	}
+	return fmt.Sprintf("add %s %d %s %s %s", h.Name, h.Ttl, dns.ClassToString[h.Class], dns.TypeToString[h.Rrtype], rdata(rr))
+}
@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+func TestDryRunUpdate(t *testing.T) {
+	a := mustRR("www.example.com. 300 IN A 192.0.2.1")
+	txt := mustRR(`www.example.com. 300 IN TXT "hello world"`)
+
+	var records []dns.RR
+	records = append(records, RemoveName([]string{"old.example.com."})...)
+	records = append(records, RemoveRRset([]dns.RR{txt})...)
+	records = append(records, Remove([]dns.RR{a})...)
+	records = append(records, a, txt)
+
+	want := `zone example.com.
+update delete old.example.com.
+update delete www.example.com. TXT
+update delete www.example.com. A 192.0.2.1
+update add www.example.com. 300 IN A 192.0.2.1
+update add www.example.com. 300 IN TXT "hello world"
+send
+`
+
+	var buf bytes.Buffer
+	u := NewDryRun(&buf)
+	defer u.Close()
+	if err := u.Update("example.com", records); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if have := buf.String(); have != want {
+		t.Errorf("got:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestFormatPrereq(t *testing.T) {
+	a := mustRR("www.example.com. 300 IN A 192.0.2.1")
+
+	msg := newUpdate("example.com.", nil)
+	msg.NameUsed([]dns.RR{a})
+	msg.NameNotUsed([]dns.RR{a})
+	msg.RRsetUsed([]dns.RR{a})
+	msg.RRsetNotUsed([]dns.RR{a})
+	msg.Used([]dns.RR{a})
+
+	want := []string{
+		"yxdomain www.example.com.",
+		"nxdomain www.example.com.",
+		"yxrrset www.example.com. A",
+		"nxrrset www.example.com. A",
+		"yxrrset www.example.com. A 192.0.2.1",
+	}
+	for i, rr := range msg.Answer {
+		if have := formatPrereq(rr); have != want[i] {
+			t.Errorf("%d: got %q, want %q", i, have, want[i])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseError is an error response from a server to an update.
+type ResponseError struct {
+	Server string
+	// The zone that was updated. A FQDN.
+	Zone string
+	// The rcode including the EDNS extended rcode.
+	Rcode int
+	// The error of the response's TSIG record, such as dns.RcodeBadSig,
+	// dns.RcodeBadKey or dns.RcodeBadTime. 0 if there is none.
+	TSIGError int
+	// The RFC 8914 Extended DNS Error of the response or nil if there is none.
+	EDE *dns.EDNS0_EDE
+}
+
+func newResponseError(server string, zone string, r *dns.Msg) *ResponseError {
+	e := &ResponseError{Server: server, Zone: dns.Fqdn(zone), Rcode: r.Rcode}
+	if t := r.IsTsig(); t != nil {
+		e.TSIGError = int(t.Error)
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ede, ok := o.(*dns.EDNS0_EDE); ok {
+				e.EDE = ede
+				break
+			}
+		}
+	}
+	return e
+}
+
+func (e *ResponseError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rfc2136: %s: update %s: got rcode %s", e.Server, e.Zone, rcodeString(e.Rcode))
+	if e.TSIGError != 0 {
+		fmt.Fprintf(&b, ", TSIG error %s", rcodeString(e.TSIGError))
+	}
+	if e.EDE != nil {
+		fmt.Fprintf(&b, ", EDE %d (%s)", e.EDE.InfoCode, dns.ExtendedErrorCodeToString[e.EDE.InfoCode])
+		if e.EDE.ExtraText != "" {
+			fmt.Fprintf(&b, ": %s", e.EDE.ExtraText)
+		}
+	}
+	return b.String()
+}
+
+// IsAuthError returns true if the server rejected the TSIG or GSS-TSIG
+// signature of the update rather than the update itself.
+func (e *ResponseError) IsAuthError() bool {
+	return e.TSIGError != 0
+}
+
+func rcodeString(rcode int) string {
+	if s, ok := dns.RcodeToString[rcode]; ok {
+		return s
+	}
+	return fmt.Sprint(rcode)
+}
@@ -0,0 +1,116 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Refuses all updates with an Extended DNS Error and a TSIG error.
+type refusingDNS struct {
+	rcode     int
+	tsigError uint16
+	ede       *dns.EDNS0_EDE
+}
+
+// Exchange implements dnsExchanger
+func (d *refusingDNS) Exchange(msg *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	r := new(dns.Msg).SetRcode(msg, d.rcode)
+	if d.ede != nil {
+		r.SetEdns0(dns.DefaultMsgSize, false)
+		opt := r.IsEdns0()
+		opt.Option = append(opt.Option, d.ede)
+	}
+	if d.tsigError != 0 {
+		r.Extra = append(r.Extra, &dns.TSIG{
+			Hdr:       dns.RR_Header{Name: "key.", Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm: dns.HmacSHA256,
+			Error:     d.tsigError,
+		})
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestUpdateResponseError(t *testing.T) {
+	tests := map[string]struct {
+		dns      *refusingDNS
+		want     ResponseError
+		wantAuth bool
+	}{
+		"refused": {
+			dns: &refusingDNS{
+				rcode: dns.RcodeRefused,
+				ede:   &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeProhibited, ExtraText: "update denied"},
+			},
+			want: ResponseError{
+				Server: testNS1,
+				Zone:   "example.com.",
+				Rcode:  dns.RcodeRefused,
+				EDE:    &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeProhibited, ExtraText: "update denied"},
+			},
+		},
+		"badsig": {
+			dns:      &refusingDNS{rcode: dns.RcodeNotAuth, tsigError: dns.RcodeBadSig},
+			want:     ResponseError{Server: testNS1, Zone: "example.com.", Rcode: dns.RcodeNotAuth, TSIGError: dns.RcodeBadSig},
+			wantAuth: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := &RFC2136Updater{servers: []string{testNS1}, dns: tc.dns}
+			err := u.Update(testZone, []dns.RR{mustRR("www.example.com. 300 IN A 192.0.2.1")})
+
+			var rerr *ResponseError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("expected a ResponseError but got: %v", err)
+			}
+			if rerr.Server != tc.want.Server || rerr.Zone != tc.want.Zone || rerr.Rcode != tc.want.Rcode || rerr.TSIGError != tc.want.TSIGError {
+				t.Errorf("got %+v, want %+v", rerr, tc.want)
+			}
+			if (rerr.EDE == nil) != (tc.want.EDE == nil) || rerr.EDE != nil && *rerr.EDE != *tc.want.EDE {
+				t.Errorf("got EDE %v, want %v", rerr.EDE, tc.want.EDE)
+			}
+			if rerr.IsAuthError() != tc.wantAuth {
+				t.Errorf("got IsAuthError %t, want %t", rerr.IsAuthError(), tc.wantAuth)
+			}
+		})
+	}
+}
+
+func TestResponseErrorError(t *testing.T) {
+	tests := map[string]struct {
+		err  *ResponseError
+		want string
+	}{
+		"rcode": {
+			err:  &ResponseError{Server: testNS1, Zone: "example.com.", Rcode: dns.RcodeRefused},
+			want: "rfc2136: ns1.example.com: update example.com.: got rcode REFUSED",
+		},
+		"tsig": {
+			err:  &ResponseError{Server: testNS1, Zone: "example.com.", Rcode: dns.RcodeNotAuth, TSIGError: dns.RcodeBadTime},
+			want: "rfc2136: ns1.example.com: update example.com.: got rcode NOTAUTH, TSIG error BADTIME",
+		},
+		"ede": {
+			err: &ResponseError{
+				Server: testNS1,
+				Zone:   "example.com.",
+				Rcode:  dns.RcodeRefused,
+				EDE:    &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeProhibited, ExtraText: "update denied"},
+			},
+			want: "rfc2136: ns1.example.com: update example.com.: got rcode REFUSED, EDE 18 (Prohibited): update denied",
+		},
+		"unknown rcode": {
+			err:  &ResponseError{Server: testNS1, Zone: "example.com.", Rcode: 3841},
+			want: "rfc2136: ns1.example.com: update example.com.: got rcode 3841",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if have := tc.err.Error(); have != tc.want {
+				t.Errorf("got %q, want %q", have, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package updater
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The default Prometheus histogram buckets in seconds.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects counters and histograms of the requests sent by the
+// updaters that it is passed to with WithMetrics. It writes them in the
+// Prometheus text format. A nil *Metrics records nothing.
+type Metrics struct {
+	mu sync.Mutex
+
+	updates          *metric
+	records          *metric
+	failures         *metric
+	tkeyDuration     *metric
+	exchangeDuration *metric
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		updates: &metric{
+			name:   "dnsupdater_updates_total",
+			help:   "UPDATE messages sent.",
+			labels: []string{"server"},
+		},
+		records: &metric{
+			name:   "dnsupdater_update_records_total",
+			help:   "Records in the update section of accepted UPDATE messages.",
+			labels: []string{"server"},
+		},
+		failures: &metric{
+			name:   "dnsupdater_update_failures_total",
+			help:   "UPDATE messages that failed by rcode, or none if there was no response.",
+			labels: []string{"server", "rcode"},
+		},
+		tkeyDuration: &metric{
+			name:    "dnsupdater_tkey_negotiation_duration_seconds",
+			help:    "Time taken to negotiate GSS-TSIG contexts.",
+			labels:  []string{"server"},
+			buckets: durationBuckets,
+		},
+		exchangeDuration: &metric{
+			name:    "dnsupdater_exchange_duration_seconds",
+			help:    "Time taken to get a response to a message by opcode.",
+			labels:  []string{"server", "opcode"},
+			buckets: durationBuckets,
+		},
+	}
+}
+
+// WithMetrics records metrics of the requests sent by u in m.
+func (u *RFC2136Updater) WithMetrics(m *Metrics) {
+	u.metrics = m
+}
+
+// A counter, or a histogram if buckets is set, with a series for each set of label values.
+type metric struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	// By the label values joined with \xff.
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// The value of a counter or the sum of a histogram.
+	value float64
+	// The observations in each bucket of a histogram.
+	counts []uint64
+	count  uint64
+}
+
+func (m *Metrics) updateSent(server string) {
+	if m != nil {
+		m.add(m.updates, 1, server)
+	}
+}
+
+func (m *Metrics) updateAccepted(server string, records int) {
+	if m != nil {
+		m.add(m.records, float64(records), server)
+	}
+}
+
+// rcode is none if there was no response.
+func (m *Metrics) updateFailed(server string, rcode string) {
+	if m != nil {
+		m.add(m.failures, 1, server, rcode)
+	}
+}
+
+func (m *Metrics) tkeyNegotiated(server string, d time.Duration) {
+	if m != nil {
+		m.observe(m.tkeyDuration, d.Seconds(), server)
+	}
+}
+
+func (m *Metrics) exchanged(server string, opcode int, d time.Duration) {
+	if m != nil {
+		m.observe(m.exchangeDuration, d.Seconds(), server, dns.OpcodeToString[opcode])
+	}
+}
+
+func (m *Metrics) add(mt *metric, v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt.get(labelValues).value += v
+}
+
+func (m *Metrics) observe(mt *metric, v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := mt.get(labelValues)
+	s.value += v
+	s.count++
+	// The first bucket whose upper bound is at least v.
+	if i, _ := slices.BinarySearch(mt.buckets, v); i < len(mt.buckets) {
+		s.counts[i]++
+	}
+}
+
+func (mt *metric) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := mt.series[key]
+	if !ok {
+		if mt.series == nil {
+			mt.series = map[string]*series{}
+		}
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(mt.buckets))}
+		mt.series[key] = s
+	}
+	return s
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, mt := range []*metric{m.updates, m.records, m.failures, m.tkeyDuration, m.exchangeDuration} {
+		mt.write(cw)
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+func (mt *metric) write(w io.Writer) {
+	typ := "counter"
+	if mt.buckets != nil {
+		typ = "histogram"
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", mt.name, mt.help, mt.name, typ)
+
+	keys := make([]string, 0, len(mt.series))
+	for k := range mt.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		s := mt.series[k]
+		labels := labelString(mt.labels, s.labelValues)
+		if mt.buckets == nil {
+			fmt.Fprintf(w, "%s{%s} %s\n", mt.name, labels, formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, b := range mt.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", mt.name, labels, formatFloat(b), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", mt.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", mt.name, labels, formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", mt.name, labels, s.count)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(names []string, values []string) string {
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = n + `="` + labelValueReplacer.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counts the bytes written to w and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteFile writes the metrics to path for the node_exporter textfile collector.
+// The file is replaced atomically so that the collector never reads a partial file.
+func (m *Metrics) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// CreateTemp creates the file with mode 0600.
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := NewMetrics()
+	m.updateSent(testNS1)
+	m.updateSent(testNS1)
+	m.updateAccepted(testNS1, 3)
+	m.updateFailed(testNS1, "REFUSED")
+	m.updateFailed(`ns"2`, "none")
+	m.tkeyNegotiated(testNS1, 20*time.Millisecond)
+	m.tkeyNegotiated(testNS1, time.Second)
+	m.tkeyNegotiated(testNS1, time.Minute)
+
+	want := `# HELP dnsupdater_updates_total UPDATE messages sent.
+# TYPE dnsupdater_updates_total counter
+dnsupdater_updates_total{server="ns1.example.com"} 2
+# HELP dnsupdater_update_records_total Records in the update section of accepted UPDATE messages.
+# TYPE dnsupdater_update_records_total counter
+dnsupdater_update_records_total{server="ns1.example.com"} 3
+# HELP dnsupdater_update_failures_total UPDATE messages that failed by rcode, or none if there was no response.
+# TYPE dnsupdater_update_failures_total counter
+dnsupdater_update_failures_total{server="ns\"2",rcode="none"} 1
+dnsupdater_update_failures_total{server="ns1.example.com",rcode="REFUSED"} 1
+# HELP dnsupdater_tkey_negotiation_duration_seconds Time taken to negotiate GSS-TSIG contexts.
+# TYPE dnsupdater_tkey_negotiation_duration_seconds histogram
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.005"} 0
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.01"} 0
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.025"} 1
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.05"} 1
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.1"} 1
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.25"} 1
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="0.5"} 1
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="1"} 2
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="2.5"} 2
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="5"} 2
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="10"} 2
+dnsupdater_tkey_negotiation_duration_seconds_bucket{server="ns1.example.com",le="+Inf"} 3
+dnsupdater_tkey_negotiation_duration_seconds_sum{server="ns1.example.com"} 61.02
+dnsupdater_tkey_negotiation_duration_seconds_count{server="ns1.example.com"} 3
+# HELP dnsupdater_exchange_duration_seconds Time taken to get a response to a message by opcode.
+# TYPE dnsupdater_exchange_duration_seconds histogram
+`
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if have := b.String(); have != want {
+		t.Errorf("got:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestMetricsUpdate(t *testing.T) {
+	m := NewMetrics()
+	record := []dns.RR{mustRR("www.example.com. 300 IN A 192.0.2.1")}
+
+	ok := &RFC2136Updater{servers: []string{testNS1}, dns: &verifyDNS{}}
+	ok.WithMetrics(m)
+	if err := ok.Update(testZone, record); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	refused := &RFC2136Updater{servers: []string{testNS2}, dns: &refusingDNS{rcode: dns.RcodeRefused}}
+	refused.WithMetrics(m)
+	if err := refused.Update(testZone, record); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	for _, want := range []string{
+		`dnsupdater_updates_total{server="ns1.example.com"} 1`,
+		`dnsupdater_updates_total{server="ns2.example.com"} 1`,
+		`dnsupdater_update_records_total{server="ns1.example.com"} 1`,
+		`dnsupdater_update_failures_total{server="ns2.example.com",rcode="REFUSED"} 1`,
+		`dnsupdater_exchange_duration_seconds_count{server="ns1.example.com",opcode="UPDATE"} 1`,
+		`dnsupdater_exchange_duration_seconds_count{server="ns2.example.com",opcode="UPDATE"} 1`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsupdater.prom")
+	m := NewMetrics()
+	m.updateSent(testNS1)
+	if err := m.WriteFile(path); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if !strings.Contains(string(b), `dnsupdater_updates_total{server="ns1.example.com"} 1`) {
+		t.Errorf("got %s", b)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected the temporary file to be removed but got %v", entries)
+	}
+}
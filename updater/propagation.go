@@ -0,0 +1,153 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// How often servers are polled while waiting for propagation.
+const propagationInterval = time.Second
+
+// WaitForPropagation polls servers, or the zone's NS records if servers is empty,
+// until each serves an SOA serial at least as new as the primary's and the
+// RRsets changed by the update section records, or until timeout has passed.
+// The error lists the servers that lagged.
+func (u *RFC2136Updater) WaitForPropagation(zone string, records []dns.RR, servers []string, timeout time.Duration) error {
+	zone = dns.Fqdn(zone)
+	deadline := u.clock().Add(timeout)
+	serial, primary, err := u.primarySerial(zone)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		if servers, err = u.nsServers(zone, primary); err != nil {
+			return err
+		}
+	}
+
+	rrsets := expectedRRsets(records)
+	pending := slices.Clone(servers)
+	var lagging []error
+	for {
+		lagging = nil
+		pending = slices.DeleteFunc(pending, func(server string) bool {
+			// Each query waits at most until the deadline.
+			left := deadline.Sub(u.clock())
+			if left <= 0 {
+				lagging = append(lagging, fmt.Errorf("%s: %w", server, os.ErrDeadlineExceeded))
+				return false
+			}
+			err := u.propagated(server, zone, serial, rrsets, left)
+			if err != nil {
+				lagging = append(lagging, fmt.Errorf("%s: %w", server, err))
+			}
+			return err == nil
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+		// Another poll wouldn't finish before the deadline.
+		if deadline.Sub(u.clock()) <= propagationInterval {
+			break
+		}
+		if err := u.wait(propagationInterval); err != nil {
+			return fmt.Errorf("wait for propagation of %s: %w", zone, err)
+		}
+	}
+	return fmt.Errorf("%s: not propagated after %s: %w", zone, timeout, errors.Join(lagging...))
+}
+
+// Returns the zone's SOA serial from the first of the zone's servers that answers.
+func (u *RFC2136Updater) primarySerial(zone string) (uint32, string, error) {
+	servers, err := u.zoneServers(zone)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, server := range servers {
+		var serial uint32
+		serial, err = u.serial(server, zone, 0)
+		if err == nil {
+			return serial, server, nil
+		}
+	}
+	return 0, "", fmt.Errorf("get SOA serial of %s: %w", zone, err)
+}
+
+// Waits at most timeout for the response unless it is 0.
+func (u *RFC2136Updater) serial(server string, zone string, timeout time.Duration) (uint32, error) {
+	records, err := u.queryUnsignedTimeout(server, zone, dns.TypeSOA, timeout)
+	if err != nil {
+		return 0, err
+	}
+	for _, rr := range records {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, errors.New("no SOA record")
+}
+
+// Returns the addresses of the zone's NS records on server.
+func (u *RFC2136Updater) nsServers(zone string, server string) ([]string, error) {
+	records, err := u.queryUnsigned(server, zone, dns.TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("get NS of %s: %w", zone, err)
+	}
+	lookupHost := u.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+	var servers []string
+	for _, rr := range records {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		addrs, err := lookupHost(strings.TrimSuffix(ns.Ns, "."))
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", ns.Ns, err)
+		}
+		for _, a := range addrs {
+			servers = append(servers, net.JoinHostPort(a, "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s has no NS records", zone)
+	}
+	return servers, nil
+}
+
+// Returns nil if server serves serial (or newer) and rrsets.
+// Each query waits at most timeout for the response.
+func (u *RFC2136Updater) propagated(server string, zone string, serial uint32, rrsets []*rrsetState, timeout time.Duration) error {
+	start := u.clock()
+	s, err := u.serial(server, zone, timeout)
+	if err != nil {
+		return err
+	}
+	// RFC 1982 serial number arithmetic.
+	if int32(s-serial) < 0 {
+		return fmt.Errorf("got serial %d, want %d", s, serial)
+	}
+	for _, rrset := range rrsets {
+		left := timeout - u.clock().Sub(start)
+		if left <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		got, err := u.queryUnsignedTimeout(server, rrset.name, rrset.rrtype, left)
+		if err != nil {
+			return err
+		}
+		if !rrset.matches(got) {
+			return fmt.Errorf("%s %s does not match the update", rrset.name, dns.TypeToString[rrset.rrtype])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Serves newZone from servers whose step in current has been reached and oldZone from the rest.
+// The step is advanced by sleep. Each response takes delay on clock.
+type propagationDNS struct {
+	step    int
+	current map[string]int
+	newZone []dns.RR
+	oldZone []dns.RR
+	clock   time.Time
+	delay   time.Duration
+}
+
+// Exchange implements dnsExchanger
+func (d *propagationDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	return d.ExchangeTimeout(msg, server, time.Hour)
+}
+
+// ExchangeTimeout implements timeoutExchanger
+func (d *propagationDNS) ExchangeTimeout(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, time.Duration, error) {
+	if d.delay > timeout {
+		d.clock = d.clock.Add(timeout)
+		return nil, timeout, os.ErrDeadlineExceeded
+	}
+	d.clock = d.clock.Add(d.delay)
+	zone := d.oldZone
+	if step, ok := d.current[server]; ok && d.step >= step {
+		zone = d.newZone
+	}
+	r := new(dns.Msg).SetReply(msg)
+	q := msg.Question[0]
+	for _, rr := range zone {
+		if strings.EqualFold(rr.Header().Name, q.Name) && rr.Header().Rrtype == q.Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	soa := func(serial string) dns.RR {
+		return mustRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. " + serial + " 3600 600 86400 3600")
+	}
+	ns := []dns.RR{
+		mustRR("example.com. 3600 IN NS ns1.example.com."),
+		mustRR("example.com. 3600 IN NS ns2.example.com."),
+	}
+	a := mustRR("www.example.com. 300 IN A 192.0.2.1")
+	hosts := map[string][]string{"ns1.example.com": {"192.0.2.1"}, "ns2.example.com": {"192.0.2.2"}}
+
+	tests := map[string]struct {
+		servers   []string
+		current   map[string]int
+		newZone   []dns.RR
+		oldZone   []dns.RR
+		delay     time.Duration
+		wantSleep int
+		// Servers that must be in the error.
+		wantLagging []string
+	}{
+		"ns": {
+			current:   map[string]int{testNS1: 0, "192.0.2.1:53": 0, "192.0.2.2:53": 2},
+			newZone:   append([]dns.RR{soa("10"), a}, ns...),
+			oldZone:   append([]dns.RR{soa("9")}, ns...),
+			wantSleep: 2,
+		},
+		"configured servers": {
+			servers:     []string{"192.0.2.2:53", "192.0.2.3:53"},
+			current:     map[string]int{testNS1: 0, "192.0.2.2:53": 0},
+			newZone:     []dns.RR{soa("10"), a},
+			oldZone:     []dns.RR{soa("9")},
+			wantSleep:   4,
+			wantLagging: []string{"192.0.2.3:53"},
+		},
+		"records": {
+			servers:     []string{"192.0.2.2:53"},
+			current:     map[string]int{testNS1: 0},
+			newZone:     []dns.RR{soa("10"), a},
+			oldZone:     []dns.RR{soa("10")},
+			wantSleep:   4,
+			wantLagging: []string{"192.0.2.2:53"},
+		},
+		"serial wrap": {
+			servers:     []string{"192.0.2.2:53"},
+			current:     map[string]int{testNS1: 0},
+			newZone:     []dns.RR{soa("1"), a},
+			oldZone:     []dns.RR{soa("4294967295"), a},
+			wantSleep:   4,
+			wantLagging: []string{"192.0.2.2:53"},
+		},
+		// The queries are cut off at the deadline.
+		"slow": {
+			servers:     []string{"192.0.2.2:53"},
+			current:     map[string]int{testNS1: 0},
+			newZone:     []dns.RR{soa("10"), a},
+			oldZone:     []dns.RR{soa("9")},
+			delay:       1500 * time.Millisecond,
+			wantSleep:   1,
+			wantLagging: []string{"192.0.2.2:53"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &propagationDNS{current: tc.current, newZone: tc.newZone, oldZone: tc.oldZone, delay: tc.delay}
+			var sleeps int
+			u := &RFC2136Updater{
+				servers: []string{testNS1},
+				dns:     d,
+				sleep: func(s time.Duration) {
+					sleeps++
+					d.step++
+					d.clock = d.clock.Add(s)
+				},
+				now: func() time.Time { return d.clock },
+				lookupHost: func(host string) ([]string, error) {
+					if addrs, ok := hosts[host]; ok {
+						return addrs, nil
+					}
+					return nil, errors.New("not found")
+				},
+			}
+
+			err := u.WaitForPropagation(testZone, []dns.RR{a}, tc.servers, 5*time.Second)
+			if err != nil && len(tc.wantLagging) == 0 {
+				t.Errorf("expected no error but got: %v", err)
+			} else if err == nil && len(tc.wantLagging) > 0 {
+				t.Errorf("expected an error")
+			}
+			for _, s := range tc.wantLagging {
+				if err != nil && !strings.Contains(err.Error(), s) {
+					t.Errorf("expected %s to be in the error: %v", s, err)
+				}
+			}
+			if have := d.clock.Sub(time.Time{}); have > 5*time.Second {
+				t.Errorf("took %s, want at most 5s", have)
+			}
+			if sleeps != tc.wantSleep {
+				t.Errorf("got %d sleeps, want %d", sleeps, tc.wantSleep)
+			}
+		})
+	}
+}
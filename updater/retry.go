@@ -0,0 +1,108 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Action is what to do after a request to a server fails.
+type Action int
+
+const (
+	// ActionFailover tries the next server.
+	ActionFailover Action = iota
+	// ActionRetry retries the same server after a backoff.
+	// Once the attempts are used up, the next server is tried.
+	ActionRetry
+	// ActionFail fails without trying any other servers.
+	ActionFail
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionFailover:
+		return "failover"
+	case ActionRetry:
+		return "retry"
+	case ActionFail:
+		return "fail"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// RetryPolicy controls how failed requests to a single server are handled.
+type RetryPolicy struct {
+	// The maximum number of attempts per server. Values less than 1 mean 1.
+	MaxAttempts int
+	// The backoff before the first retry. It is doubled after every retry.
+	InitialBackoff time.Duration
+	// The maximum backoff.
+	MaxBackoff time.Duration
+	// The fraction of the backoff that is randomly added or subtracted.
+	Jitter float64
+	// The action for each rcode. Other rcodes failover.
+	Rcodes map[int]Action
+	// The action for transport errors such as timeouts and closed connections.
+	TransportError Action
+}
+
+// DefaultRetryPolicy makes a single attempt per server. Permission and
+// content errors fail immediately since every server would return the same.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    1,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+		Rcodes: map[int]Action{
+			dns.RcodeServerFailure:  ActionRetry,
+			dns.RcodeNotImplemented: ActionFailover,
+			dns.RcodeNotAuth:        ActionFailover,
+			dns.RcodeRefused:        ActionFail,
+			dns.RcodeFormatError:    ActionFail,
+			dns.RcodeNameError:      ActionFail,
+			dns.RcodeYXDomain:       ActionFail,
+			dns.RcodeYXRrset:        ActionFail,
+			dns.RcodeNXRrset:        ActionFail,
+			dns.RcodeNotZone:        ActionFail,
+		},
+		TransportError: ActionRetry,
+	}
+}
+
+// Returns the action for err.
+func (p *RetryPolicy) action(err error) Action {
+	var rerr *ResponseError
+	if errors.As(err, &rerr) {
+		if a, ok := p.Rcodes[rerr.Rcode]; ok {
+			return a
+		}
+		return ActionFailover
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return p.TransportError
+	}
+	return ActionFailover
+}
+
+// Returns the backoff before retry number n (starting at 1).
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(p.Jitter * (2*rand.Float64() - 1) * float64(d))
+	}
+	return d
+}
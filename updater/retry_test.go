@@ -0,0 +1,195 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRetryPolicyAction(t *testing.T) {
+	p := DefaultRetryPolicy()
+	p.Rcodes[dns.RcodeBadSig] = ActionRetry
+
+	tests := map[string]struct {
+		err  error
+		want Action
+	}{
+		"servfail":     {err: &ResponseError{Rcode: dns.RcodeServerFailure}, want: ActionRetry},
+		"refused":      {err: &ResponseError{Rcode: dns.RcodeRefused}, want: ActionFail},
+		"notauth":      {err: &ResponseError{Rcode: dns.RcodeNotAuth}, want: ActionFailover},
+		"custom":       {err: &ResponseError{Rcode: dns.RcodeBadSig}, want: ActionRetry},
+		"unknown":      {err: &ResponseError{Rcode: dns.RcodeBadCookie}, want: ActionFailover},
+		"wrapped":      {err: fmt.Errorf("wrapped: %w", &ResponseError{Rcode: dns.RcodeRefused}), want: ActionFail},
+		"timeout":      {err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, want: ActionRetry},
+		"eof":          {err: fmt.Errorf("rfc2136: %w", io.EOF), want: ActionRetry},
+		"other errors": {err: errors.New("tkey error"), want: ActionFailover},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if have := p.action(tc.err); have != tc.want {
+				t.Errorf("got %s, want %s", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if have := p.backoff(i + 1); have != w {
+			t.Errorf("%d: got %s, want %s", i+1, have, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if have := p.backoff(1); have < 500*time.Millisecond || have > 1500*time.Millisecond {
+			t.Fatalf("got %s, want between 500ms and 1.5s", have)
+		}
+	}
+}
+
+// Returns the responses in order for each server. A response is an rcode or an error.
+type scriptedDNS struct {
+	responses map[string][]any
+	exchanges map[string]int
+}
+
+// Exchange implements dnsExchanger
+func (d *scriptedDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	if d.exchanges == nil {
+		d.exchanges = map[string]int{}
+	}
+	i := d.exchanges[server]
+	d.exchanges[server]++
+
+	if i >= len(d.responses[server]) {
+		return &dns.Msg{}, time.Millisecond, nil
+	}
+	switch r := d.responses[server][i].(type) {
+	case int:
+		return &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: r}}, time.Millisecond, nil
+	case error:
+		return nil, time.Millisecond, r
+	}
+	panic("unexpected response type")
+}
+
+func TestUpdateRetry(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+	retry := &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+		Rcodes:         DefaultRetryPolicy().Rcodes,
+		TransportError: ActionRetry,
+	}
+
+	tests := map[string]struct {
+		responses map[string][]any
+		policies  map[string]*RetryPolicy
+		want      map[string]int
+		wantSleep []time.Duration
+		wantError bool
+		// Cancel the context during the first sleep.
+		cancel bool
+	}{
+		"default no retry": {
+			responses: map[string][]any{testNS1: {dns.RcodeServerFailure}},
+			want:      map[string]int{testNS1: 1, testNS2: 1},
+		},
+		"default refused": {
+			responses: map[string][]any{testNS1: {dns.RcodeRefused}},
+			want:      map[string]int{testNS1: 1},
+			wantError: true,
+		},
+		"retry servfail": {
+			responses: map[string][]any{testNS1: {dns.RcodeServerFailure, dns.RcodeServerFailure}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			want:      map[string]int{testNS1: 3},
+			wantSleep: []time.Duration{time.Second, 2 * time.Second},
+		},
+		"retry timeout then failover": {
+			responses: map[string][]any{testNS1: {timeout, timeout, timeout}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			want:      map[string]int{testNS1: 3, testNS2: 1},
+			wantSleep: []time.Duration{time.Second, 2 * time.Second},
+		},
+		"notauth failover": {
+			responses: map[string][]any{testNS1: {dns.RcodeNotAuth}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			want:      map[string]int{testNS1: 1, testNS2: 1},
+		},
+		"refused fail": {
+			responses: map[string][]any{testNS1: {dns.RcodeRefused}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			want:      map[string]int{testNS1: 1},
+			wantError: true,
+		},
+		"per server": {
+			responses: map[string][]any{testNS1: {timeout, timeout}, testNS2: {timeout, timeout}},
+			policies:  map[string]*RetryPolicy{testNS2: retry},
+			want:      map[string]int{testNS1: 1, testNS2: 3},
+			wantSleep: []time.Duration{time.Second, 2 * time.Second},
+		},
+		"all fail": {
+			responses: map[string][]any{testNS1: {timeout, timeout, timeout}, testNS2: {timeout, timeout, timeout}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			want:      map[string]int{testNS1: 3, testNS2: 3},
+			wantSleep: []time.Duration{time.Second, 2 * time.Second, time.Second, 2 * time.Second},
+			wantError: true,
+		},
+		"canceled": {
+			responses: map[string][]any{testNS1: {timeout, timeout, timeout}, testNS2: {timeout, timeout, timeout}},
+			policies:  map[string]*RetryPolicy{"": retry},
+			cancel:    true,
+			want:      map[string]int{testNS1: 1},
+			wantSleep: []time.Duration{time.Second},
+			wantError: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &scriptedDNS{responses: tc.responses}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var slept []time.Duration
+			u := &RFC2136Updater{
+				servers: []string{testNS1, testNS2},
+				dns:     d,
+				sleep: func(d time.Duration) {
+					slept = append(slept, d)
+					if tc.cancel {
+						cancel()
+					}
+				},
+			}
+			u.WithContext(ctx)
+			for server, p := range tc.policies {
+				u.WithRetry(server, p)
+			}
+
+			err := u.Update(testZone, []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "test"}}})
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(d.exchanges, tc.want) {
+				t.Errorf("got exchanges %v, want %v", d.exchanges, tc.want)
+			}
+			if !reflect.DeepEqual(slept, tc.wantSleep) {
+				t.Errorf("got sleeps %v, want %v", slept, tc.wantSleep)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
 package updater
 
 import (
+	"context"
+	"crypto"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bodgit/tsig"
@@ -13,6 +19,15 @@ type dnsExchanger interface {
 	Exchange(*dns.Msg, string) (*dns.Msg, time.Duration, error)
 }
 
+// timeoutExchanger is implemented by dnsExchangers that can wait less than their default timeout.
+type timeoutExchanger interface {
+	ExchangeTimeout(*dns.Msg, string, time.Duration) (*dns.Msg, time.Duration, error)
+}
+
+type zoneTransferer interface {
+	In(*dns.Msg, string) (chan *dns.Envelope, error)
+}
+
 type gssNegotiator interface {
 	NegotiateContextWithCredentials(string, string, string, string) (string, time.Time, error)
 	NegotiateContext(string) (string, time.Time, error)
@@ -20,39 +35,115 @@ type gssNegotiator interface {
 	Close() error
 }
 
+// Contexts are renegotiated this long before they expire.
+const tkeyExpiryMargin = time.Minute
+
+type gssContext struct {
+	key    string
+	expiry time.Time
+}
+
+// RFC2136Updater sends updates to the first server that accepts them.
+// It is safe for concurrent use once it has been configured.
 type RFC2136Updater struct {
 	servers []string
-	dns     dnsExchanger
-	gss     gssNegotiator
+	// Holds the timeouts and TSIG settings used by dns.
+	client *dns.Client
+	dns    dnsExchanger
+	gss    gssNegotiator
+
+	// newTransfer returns a zoneTransferer for a single zone transfer.
+	newTransfer func() zoneTransferer
 
 	// For GSS
 	username string
 	password string
 	domain   string
+	tkeysMu  sync.Mutex
+	// Negotiated contexts by server.
+	tkeys map[string]gssContext
+
+	// For TSIG
+	tsigName      string
+	tsigAlgorithm string
+
+	// For SIG(0)
+	sig0Key    *dns.KEY
+	sig0Signer crypto.Signer
+
+	// Retry policies by server. The policy for "" is used for other servers.
+	retry map[string]*RetryPolicy
+	sleep func(time.Duration)
+	// Retries and waits stop when it is done. nil if not set.
+	ctx context.Context
+	// Replaced in tests.
+	now func() time.Time
+
+	verify bool
+	// For resolving NS records when waiting for propagation.
+	lookupHost func(string) ([]string, error)
+
+	// For discovering the servers of each zone when servers is empty.
+	resolvers    func() ([]string, error)
+	discoveredMu sync.Mutex
+	discovered   map[string][]string
+
+	// nil if metrics are not collected.
+	metrics *Metrics
 }
 
-// Servers must have len > 0.
+// If servers is empty, the servers for each zone are discovered
+// from the zone's SOA and NS records.
 func NewRFC2136(servers []string) *RFC2136Updater {
-	return &RFC2136Updater{
+	client := &dns.Client{Net: "tcp"}
+	u := &RFC2136Updater{
 		servers: servers,
-		dns:     &dns.Client{Net: "tcp"},
+		client:  client,
+		dns:     newConnPool(client),
 	}
+	u.resolvers = sync.OnceValues(systemResolvers)
+	u.newTransfer = func() zoneTransferer {
+		return &dns.Transfer{TsigProvider: client.TsigProvider, TsigSecret: client.TsigSecret}
+	}
+	return u
 }
 
 func (u *RFC2136Updater) Close() error {
+	var errs []error
 	if u.gss != nil {
-		return u.gss.Close()
+		u.tkeysMu.Lock()
+		for host, c := range u.tkeys {
+			_ = u.gss.DeleteContext(c.key)
+			delete(u.tkeys, host)
+		}
+		u.tkeysMu.Unlock()
+		errs = append(errs, u.gss.Close())
 	}
-	return nil
+	if p, ok := u.dns.(*connPool); ok {
+		errs = append(errs, p.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// WithPipelining sends requests to a server without waiting for the
+// responses to earlier ones. The server must support it (RFC 7766).
+// By default, only one request at a time is sent over each connection.
+func (u *RFC2136Updater) WithPipelining() {
+	u.dns.(*connPool).maxInflight = 0
+}
+
+// WithContext stops retrying requests and waiting for propagation when ctx is done.
+func (u *RFC2136Updater) WithContext(ctx context.Context) {
+	u.ctx = ctx
 }
 
 func (u *RFC2136Updater) WithGSS() error {
-	gssClient, err := gss.NewClient(u.dns.(*dns.Client))
+	gssClient, err := gss.NewClient(u.client)
 	if err != nil {
 		return err
 	}
 	u.gss = gssClient
-	u.dns.(*dns.Client).TsigProvider = gssClient
+	u.client.TsigProvider = gssClient
 	return err
 }
 
@@ -62,60 +153,348 @@ func (u *RFC2136Updater) WithCredentials(username, password, domain string) {
 	u.domain = domain
 }
 
-func (u *RFC2136Updater) getTKEY(host string) (string, func(), error) {
+// WithTSIG signs updates with the base64 encoded secret of the key name
+// using algorithm (for example hmac-sha256).
+func (u *RFC2136Updater) WithTSIG(name, algorithm, secret string) {
+	u.tsigName = dns.CanonicalName(name)
+	u.tsigAlgorithm = dns.CanonicalName(algorithm)
+	u.client.TsigSecret = map[string]string{u.tsigName: secret}
+}
+
+// WithSIG0 signs updates using SIG(0) with the key in the BIND style
+// K<name>+<alg>+<id>.private file and the KEY record in the matching .key file.
+// keyFile may be the path to either file.
+func (u *RFC2136Updater) WithSIG0(keyFile string) error {
+	base := strings.TrimSuffix(strings.TrimSuffix(keyFile, ".private"), ".key")
+
+	f, err := os.Open(base + ".key")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, f.Name())
+	if err != nil {
+		return err
+	}
+	key, ok := rr.(*dns.KEY)
+	if !ok {
+		return fmt.Errorf("%s: expected a KEY record but got %s", f.Name(), dns.TypeToString[rr.Header().Rrtype])
+	}
+
+	pf, err := os.Open(base + ".private")
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	priv, err := key.ReadPrivateKey(pf, pf.Name())
+	if err != nil {
+		return err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("%s: unsupported private key", pf.Name())
+	}
+
+	u.sig0Key = key
+	u.sig0Signer = signer
+	return nil
+}
+
+// WithRetry sets the retry policy for server,
+// or for all servers without their own policy if server is empty.
+func (u *RFC2136Updater) WithRetry(server string, p *RetryPolicy) {
+	if u.retry == nil {
+		u.retry = map[string]*RetryPolicy{}
+	}
+	u.retry[server] = p
+}
+
+func (u *RFC2136Updater) retryPolicy(server string) *RetryPolicy {
+	if p, ok := u.retry[server]; ok {
+		return p
+	}
+	if p, ok := u.retry[""]; ok {
+		return p
+	}
+	return DefaultRetryPolicy()
+}
+
+// Call f with each server for zone until it succeeds or returns an error
+// whose action is ActionFail.
+func (u *RFC2136Updater) try(zone string, f func(server string) error) error {
+	servers, err := u.zoneServers(zone)
+	if err != nil {
+		return err
+	}
+	for _, srv := range servers {
+		var action Action
+		action, err = u.tryServer(srv, f)
+		if err == nil || action == ActionFail {
+			break
+		}
+	}
+	return err
+}
+
+// Call f with server, retrying according to the server's retry policy.
+// Returns the action for the last error.
+func (u *RFC2136Updater) tryServer(server string, f func(server string) error) (Action, error) {
+	p := u.retryPolicy(server)
+	for attempt := 1; ; attempt++ {
+		if err := u.ctxErr(); err != nil {
+			return ActionFail, err
+		}
+		err := f(server)
+		if err == nil {
+			return ActionFailover, nil
+		}
+		action := p.action(err)
+		if action != ActionRetry {
+			return action, err
+		}
+		if attempt >= p.MaxAttempts {
+			return ActionFailover, err
+		}
+		if werr := u.wait(p.backoff(attempt)); werr != nil {
+			return ActionFail, errors.Join(err, werr)
+		}
+	}
+}
+
+// Sleep for d using u.sleep if it is set.
+// Returns the context's error if it is done before d has passed.
+func (u *RFC2136Updater) wait(d time.Duration) error {
+	if u.sleep != nil {
+		u.sleep(d)
+		return u.ctxErr()
+	}
+	if u.ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-u.ctx.Done():
+		return u.ctx.Err()
+	}
+}
+
+// Returns the error of the context set by WithContext, if any.
+func (u *RFC2136Updater) ctxErr() error {
+	if u.ctx == nil {
+		return nil
+	}
+	return u.ctx.Err()
+}
+
+// Returns the current time using u.now if it is set.
+func (u *RFC2136Updater) clock() time.Time {
+	if u.now != nil {
+		return u.now()
+	}
+	return time.Now()
+}
+
+// Returns the GSS context for host, negotiating a new one
+// if there is no cached context or it is about to expire.
+// Returns "" if GSS is not used.
+func (u *RFC2136Updater) getTKEY(host string) (string, error) {
 	if u.gss == nil {
-		return "", nil, nil
+		return "", nil
+	}
+	// Hold the lock while negotiating so that only one context is negotiated per host.
+	u.tkeysMu.Lock()
+	defer u.tkeysMu.Unlock()
+
+	if c, ok := u.tkeys[host]; ok {
+		if time.Until(c.expiry) > tkeyExpiryMargin {
+			return c.key, nil
+		}
+		delete(u.tkeys, host)
+		// An error is returned from DeleteContext only if the key is not found.
+		// Therefore, we can safely ignore it.
+		_ = u.gss.DeleteContext(c.key)
 	}
+
 	var key string
+	var expiry time.Time
 	var err error
+	start := time.Now()
 	if u.username != "" && u.password != "" && u.domain != "" {
-		key, _, err = u.gss.NegotiateContextWithCredentials(host, u.domain, u.username, u.password)
+		key, expiry, err = u.gss.NegotiateContextWithCredentials(host, u.domain, u.username, u.password)
 	} else {
-		key, _, err = u.gss.NegotiateContext(host)
+		key, expiry, err = u.gss.NegotiateContext(host)
 	}
+	u.metrics.tkeyNegotiated(host, time.Since(start))
 	if err != nil {
-		return "", nil, err
+		return "", err
+	}
+	if u.tkeys == nil {
+		u.tkeys = map[string]gssContext{}
 	}
-	// An error is returned from DeleteContext only if the key is not found.
-	// Therefore, we can safely ignore it.
-	return key, func() { _ = u.gss.DeleteContext(key) }, nil
+	u.tkeys[host] = gssContext{key: key, expiry: expiry}
+	return key, nil
 }
 
-func (u *RFC2136Updater) Insert(zone string, records []dns.RR) error {
-	var err error
-	for _, srv := range u.servers {
-		err = u.insert(srv, zone, records)
-		if err == nil {
-			break
+// Delete the GSS context key for host so that the next request negotiates a new one.
+func (u *RFC2136Updater) dropTKEY(host string, key string) {
+	u.tkeysMu.Lock()
+	defer u.tkeysMu.Unlock()
+	if c, ok := u.tkeys[host]; ok && c.key == key {
+		delete(u.tkeys, host)
+	}
+	_ = u.gss.DeleteContext(key)
+}
+
+// Returns true if r shows that the server rejected the GSS context.
+func rejectedTKEY(r *dns.Msg) bool {
+	if r == nil {
+		return false
+	}
+	t := r.IsTsig()
+	return t != nil && (t.Error == dns.RcodeBadKey || t.Error == dns.RcodeBadSig)
+}
+
+// Sign msg with the GSS context tkey if it is not empty,
+// otherwise with the TSIG or SIG(0) key if there is one.
+func (u *RFC2136Updater) sign(msg *dns.Msg, tkey string) error {
+	now := time.Now()
+	switch {
+	case tkey != "":
+		msg.SetTsig(tkey, tsig.GSS, 300, now.Unix())
+	case u.tsigName != "":
+		msg.SetTsig(u.tsigName, u.tsigAlgorithm, 300, now.Unix())
+	case u.sig0Key != nil:
+		sig := &dns.SIG{
+			RRSIG: dns.RRSIG{
+				Algorithm:  u.sig0Key.Algorithm,
+				SignerName: u.sig0Key.Hdr.Name,
+				KeyTag:     u.sig0Key.KeyTag(),
+				Inception:  uint32(now.Add(-5 * time.Minute).Unix()),
+				Expiration: uint32(now.Add(5 * time.Minute).Unix()),
+			},
+		}
+		if _, err := sig.Sign(u.sig0Signer, msg); err != nil {
+			return fmt.Errorf("sig0: %w", err)
 		}
+		msg.Extra = append(msg.Extra, sig)
 	}
+	return nil
+}
+
+func (u *RFC2136Updater) Update(zone string, records []dns.RR) error {
+	_, err := u.UpdateServer(zone, records)
 	return err
 }
 
-func (u *RFC2136Updater) insert(server string, zone string, records []dns.RR) error {
-	tkey, cleanup, err := u.getTKEY(server)
-	if err != nil {
-		return fmt.Errorf("tkey %s: %w", server, err)
+// UpdateServer is like Update but also returns the server that accepted the update,
+// or the last server that was tried if it failed.
+func (u *RFC2136Updater) UpdateServer(zone string, records []dns.RR) (string, error) {
+	var server string
+	err := u.try(zone, func(s string) error {
+		server = s
+		return u.update(s, zone, records)
+	})
+	// Verify after try so that a mismatch doesn't send the update to the next server.
+	if err == nil && u.verify {
+		err = u.verifyUpdate(server, records)
 	}
-	if cleanup != nil {
-		defer cleanup()
+	return server, err
+}
+
+func (u *RFC2136Updater) update(server string, zone string, records []dns.RR) error {
+	for renegotiated := false; ; renegotiated = true {
+		tkey, err := u.getTKEY(server)
+		if err != nil {
+			return fmt.Errorf("tkey %s: %w", server, err)
+		}
+
+		msg := newUpdate(zone, records)
+		if err := u.sign(msg, tkey); err != nil {
+			return err
+		}
+
+		u.metrics.updateSent(server)
+		r, err := u.exchange(msg, server)
+		if tkey != "" && rejectedTKEY(r) {
+			u.dropTKEY(server, tkey)
+			if !renegotiated {
+				continue
+			}
+		}
+		// The response may not verify if the server rejected the signature.
+		if r != nil && r.Rcode != dns.RcodeSuccess {
+			u.metrics.updateFailed(server, rcodeString(r.Rcode))
+			return newResponseError(server, zone, r)
+		}
+		if err != nil {
+			u.metrics.updateFailed(server, "none")
+			return fmt.Errorf("rfc2136: %s: %w", server, err)
+		}
+		u.metrics.updateAccepted(server, len(records))
+		return nil
 	}
+}
 
-	msg := new(dns.Msg)
-	msg.SetUpdate(dns.Fqdn(zone))
-	msg.RecursionDesired = false
-	msg.Insert(records)
+// Send msg to server, recording the time taken to get a response.
+func (u *RFC2136Updater) exchange(msg *dns.Msg, server string) (*dns.Msg, error) {
+	return u.exchangeTimeout(msg, server, 0)
+}
 
-	if tkey != "" {
-		msg.SetTsig(tkey, tsig.GSS, 300, time.Now().Unix())
+// Like exchange, but waits at most timeout for the response unless it is 0.
+func (u *RFC2136Updater) exchangeTimeout(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	start := time.Now()
+	var r *dns.Msg
+	var err error
+	if te, ok := u.dns.(timeoutExchanger); ok && timeout > 0 {
+		r, _, err = te.ExchangeTimeout(msg, server, timeout)
+	} else {
+		r, _, err = u.dns.Exchange(msg, server)
 	}
+	u.metrics.exchanged(server, msg.Opcode, time.Since(start))
+	return r, err
+}
+
+// Transfer returns the contents of the zone using AXFR from the first server
+// that succeeds. The SOA that ends the transfer is not included.
+func (u *RFC2136Updater) Transfer(zone string) ([]dns.RR, error) {
+	var records []dns.RR
+	err := u.try(zone, func(server string) error {
+		var err error
+		records, err = u.transfer(server, zone)
+		return err
+	})
+	return records, err
+}
 
-	r, _, err := u.dns.Exchange(msg, server)
+func (u *RFC2136Updater) transfer(server string, zone string) ([]dns.RR, error) {
+	tkey, err := u.getTKEY(server)
 	if err != nil {
-		return fmt.Errorf("rfc2136: %s: %w", server, err)
+		return nil, fmt.Errorf("tkey %s: %w", server, err)
 	}
-	if r.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("rfc2136: %s: got rcode %s", server, dns.RcodeToString[r.Rcode])
+
+	msg := new(dns.Msg)
+	msg.SetAxfr(dns.Fqdn(zone))
+	if err := u.sign(msg, tkey); err != nil {
+		return nil, err
 	}
-	return nil
+
+	env, err := u.newTransfer().In(msg, server)
+	if err != nil {
+		return nil, fmt.Errorf("axfr: %s: %w", server, err)
+	}
+	var records []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return nil, fmt.Errorf("axfr: %s: %w", server, e.Error)
+		}
+		records = append(records, e.RR...)
+	}
+	if len(records) > 1 && records[len(records)-1].Header().Rrtype == dns.TypeSOA {
+		records = records[:len(records)-1]
+	}
+	return records, nil
 }
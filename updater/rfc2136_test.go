@@ -1,11 +1,18 @@
 package updater
 
 import (
+	"crypto"
 	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/bodgit/tsig"
 	"github.com/miekg/dns"
 )
 
@@ -27,6 +34,10 @@ type testGSS struct {
 
 	credentials bool
 
+	// The lifetime of negotiated contexts. Defaults to an hour.
+	lifetime time.Duration
+
+	negotiations   int
 	deletedContext string
 }
 
@@ -46,12 +57,7 @@ func (g *testGSS) NegotiateContext(string) (string, time.Time, error) {
 	if g.credentials {
 		return "", time.Time{}, errors.New("expected credentials to be used")
 	}
-
-	if g.returnError {
-		return "", time.Time{}, errors.New("returnError is true")
-	}
-
-	return "tkey", time.Time{}, nil
+	return g.negotiate()
 }
 
 // NegotiateContextWithCredentials implements gssNegotiator
@@ -59,12 +65,19 @@ func (g *testGSS) NegotiateContextWithCredentials(string, string, string, string
 	if !g.credentials {
 		return "", time.Time{}, errors.New("expected no credentials to be used")
 	}
+	return g.negotiate()
+}
 
+func (g *testGSS) negotiate() (string, time.Time, error) {
 	if g.returnError {
 		return "", time.Time{}, errors.New("returnError is true")
 	}
-
-	return "tkey", time.Time{}, nil
+	g.negotiations++
+	lifetime := g.lifetime
+	if lifetime == 0 {
+		lifetime = time.Hour
+	}
+	return testTKEY, time.Now().Add(lifetime), nil
 }
 
 func (g *testGSS) assert(t *testing.T) {
@@ -92,7 +105,7 @@ func (d *testDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration
 	if (server == testNS1 && name == ns1ServFailName) || name == allFailName {
 		rcode = dns.RcodeServerFailure
 	}
-	if hasTSIG := (len(msg.Extra) != 0 && msg.Extra[0].Header().Rrtype == dns.TypeTSIG); d.wantTSIG && !hasTSIG {
+	if hasTSIG := msg.IsTsig() != nil; d.wantTSIG && !hasTSIG {
 		rcode = dns.RcodeNotAuth
 	} else if !d.wantTSIG && hasTSIG {
 		rcode = dns.RcodeBadSig
@@ -135,6 +148,7 @@ func TestInsert(t *testing.T) {
 		username string
 		password string
 		domain   string
+		tsig     bool
 	}{
 		"no gss": {
 			dns:      &testDNS{want: map[string]int{testNS1: 1}},
@@ -156,6 +170,11 @@ func TestInsert(t *testing.T) {
 			username: "a", password: "a", domain: "a",
 			toInsert: records,
 		},
+		"tsig": {
+			dns:      &testDNS{want: map[string]int{testNS1: 1}, wantTSIG: true},
+			tsig:     true,
+			toInsert: records,
+		},
 		"ns1 error": {
 			dns:      &testDNS{want: map[string]int{testNS1: 1, testNS2: 1}},
 			toInsert: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: ns1ServFailName}}},
@@ -182,7 +201,11 @@ func TestInsert(t *testing.T) {
 			if tc.gss != nil {
 				u.gss = tc.gss
 			}
-			err := u.Insert(testZone, tc.toInsert)
+			if tc.tsig {
+				u.tsigName = "key.example.com."
+				u.tsigAlgorithm = dns.HmacSHA256
+			}
+			err := u.Update(testZone, tc.toInsert)
 			if err == nil && tc.wantError {
 				t.Errorf("expected an error")
 			} else if err != nil && !tc.wantError {
@@ -190,6 +213,9 @@ func TestInsert(t *testing.T) {
 			}
 
 			tc.dns.assert(t)
+			if err := u.Close(); err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
 			if tc.gss != nil {
 				tc.gss.assert(t)
 			}
@@ -229,3 +255,391 @@ func TestNewRFC2136(t *testing.T) {
 		t.Errorf("got domain %q, want %q", u.domain, domain)
 	}
 }
+
+func TestUpdateRemove(t *testing.T) {
+	d := &testDNS{want: map[string]int{testNS1: 1}}
+	u := &RFC2136Updater{servers: []string{testNS1, testNS2}, dns: d}
+
+	records := []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "test", Rrtype: dns.TypeA, Class: dns.ClassINET}}}
+	if err := u.Update(testZone, Remove(records)); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	d.assert(t)
+
+	if have := d.exchanges[testNS1][0].Ns[0].Header().Class; have != dns.ClassNONE {
+		t.Errorf("got class %s, want %s", dns.ClassToString[have], dns.ClassToString[dns.ClassNONE])
+	}
+}
+
+var testTransferRecords = []dns.RR{
+	&dns.SOA{Hdr: dns.RR_Header{Name: testZone + ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET}},
+	&dns.A{Hdr: dns.RR_Header{Name: "a." + testZone + ".", Rrtype: dns.TypeA, Class: dns.ClassINET}},
+	&dns.SOA{Hdr: dns.RR_Header{Name: testZone + ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET}},
+}
+
+type testTransfer struct {
+	// Servers that fail to connect.
+	dialErr map[string]bool
+	// Servers that fail during the transfer.
+	envErr map[string]bool
+
+	transfers map[string]int
+	wantTSIG  bool
+}
+
+// In implements zoneTransferer
+func (x *testTransfer) In(msg *dns.Msg, server string) (chan *dns.Envelope, error) {
+	if x.transfers == nil {
+		x.transfers = map[string]int{}
+	}
+	x.transfers[server]++
+
+	if hasTSIG := msg.IsTsig() != nil; hasTSIG != x.wantTSIG {
+		return nil, errors.New("unexpected TSIG")
+	}
+	if x.dialErr[server] {
+		return nil, errors.New("dial error")
+	}
+
+	c := make(chan *dns.Envelope, 2)
+	if x.envErr[server] {
+		c <- &dns.Envelope{Error: errors.New("envelope error")}
+	} else {
+		c <- &dns.Envelope{RR: testTransferRecords[:2]}
+		c <- &dns.Envelope{RR: testTransferRecords[2:]}
+	}
+	close(c)
+	return c, nil
+}
+
+func TestTransfer(t *testing.T) {
+	tests := map[string]struct {
+		xfr       *testTransfer
+		gss       *testGSS
+		want      map[string]int
+		wantError bool
+	}{
+		"ok": {
+			xfr:  &testTransfer{},
+			want: map[string]int{testNS1: 1},
+		},
+		"gss": {
+			xfr:  &testTransfer{wantTSIG: true},
+			gss:  &testGSS{},
+			want: map[string]int{testNS1: 1},
+		},
+		"ns1 dial error": {
+			xfr:  &testTransfer{dialErr: map[string]bool{testNS1: true}},
+			want: map[string]int{testNS1: 1, testNS2: 1},
+		},
+		"ns1 envelope error": {
+			xfr:  &testTransfer{envErr: map[string]bool{testNS1: true}},
+			want: map[string]int{testNS1: 1, testNS2: 1},
+		},
+		"all error": {
+			xfr:       &testTransfer{dialErr: map[string]bool{testNS1: true}, envErr: map[string]bool{testNS2: true}},
+			want:      map[string]int{testNS1: 1, testNS2: 1},
+			wantError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := &RFC2136Updater{
+				servers:     []string{testNS1, testNS2},
+				newTransfer: func() zoneTransferer { return tc.xfr },
+			}
+			if tc.gss != nil {
+				u.gss = tc.gss
+			}
+			records, err := u.Transfer(testZone)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(tc.xfr.transfers, tc.want) {
+				t.Errorf("got transfers %v, want %v", tc.xfr.transfers, tc.want)
+			}
+			if tc.wantError {
+				return
+			}
+			if !reflect.DeepEqual(records, testTransferRecords[:2]) {
+				t.Errorf("got records %v, want %v", records, testTransferRecords[:2])
+			}
+		})
+	}
+}
+
+// Start a DNS server on localhost that answers every message using handler.
+func startTestServer(t *testing.T, tsigSecret map[string]string, handler dns.HandlerFunc) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          l,
+		TsigSecret:        tsigSecret,
+		NotifyStartedFunc: func() { close(started) },
+		// The default rejects UPDATE messages.
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler:       handler,
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+	return l.Addr().String()
+}
+
+// Answers messages that fail TSIG verification with NOTAUTH
+// and unsigned messages with REFUSED.
+func tsigHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if t := r.IsTsig(); t == nil {
+		m.Rcode = dns.RcodeRefused
+	} else if w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeNotAuth
+	} else {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+	_ = w.WriteMsg(m)
+}
+
+// Returns a handler that answers messages that are not signed by key
+// using SIG(0) with NOTAUTH.
+func sig0Handler(key *dns.KEY) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Rcode = dns.RcodeNotAuth
+
+		var sig *dns.SIG
+		if len(r.Extra) > 0 {
+			sig, _ = r.Extra[len(r.Extra)-1].(*dns.SIG)
+		}
+		if buf, err := r.Pack(); err == nil && sig != nil && sig.Verify(key, buf) == nil {
+			m.Rcode = dns.RcodeSuccess
+		}
+		_ = w.WriteMsg(m)
+	}
+}
+
+func TestWithTSIG(t *testing.T) {
+	const (
+		keyName = "key.example.com."
+		secret  = "bTueCg5wgjWkFsoX6n+p8WWUg5/tfyoBQEhnAjNx7RI="
+	)
+	addr := startTestServer(t, map[string]string{keyName: secret}, tsigHandler)
+	records := []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "test." + testZone + ".", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 1)}}
+
+	tests := map[string]struct {
+		secret    string
+		wantError bool
+	}{
+		"valid":        {secret: secret},
+		"wrong secret": {secret: "d3Jvbmcgc2VjcmV0", wantError: true},
+		"no tsig":      {wantError: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u := NewRFC2136([]string{addr})
+			defer u.Close()
+			if tc.secret != "" {
+				u.WithTSIG("KEY.example.com", "hmac-sha256", tc.secret)
+			}
+			err := u.Update(testZone, records)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+// Write a key pair to dir in the BIND format and return the path of the .private file.
+func writeSIG0Key(t *testing.T, dir string, key *dns.KEY, priv crypto.PrivateKey) string {
+	t.Helper()
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", key.Hdr.Name, key.Algorithm, key.KeyTag()))
+	if err := os.WriteFile(base+".key", []byte(key.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".private", []byte(key.PrivateKeyString(priv)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return base + ".private"
+}
+
+func newSIG0Key(t *testing.T, alg uint8, bits int) (*dns.KEY, crypto.PrivateKey) {
+	t.Helper()
+	key := &dns.KEY{DNSKEY: dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "updater.example.com.", Rrtype: dns.TypeKEY, Class: dns.ClassINET},
+		Flags:     512,
+		Protocol:  3,
+		Algorithm: alg,
+	}}
+	priv, err := key.Generate(bits)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	return key, priv
+}
+
+func TestWithSIG0(t *testing.T) {
+	records := []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "test." + testZone + ".", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 1)}}
+
+	tests := map[string]struct {
+		alg  uint8
+		bits int
+	}{
+		"rsa":     {alg: dns.RSASHA256, bits: 2048},
+		"ecdsa":   {alg: dns.ECDSAP256SHA256, bits: 256},
+		"ed25519": {alg: dns.ED25519, bits: 256},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			key, priv := newSIG0Key(t, tc.alg, tc.bits)
+			path := writeSIG0Key(t, t.TempDir(), key, priv)
+			addr := startTestServer(t, nil, sig0Handler(key))
+
+			for _, p := range []string{path, strings.TrimSuffix(path, ".private") + ".key"} {
+				u := NewRFC2136([]string{addr})
+				if err := u.WithSIG0(p); err != nil {
+					t.Fatalf("%s: error loading key: %v", p, err)
+				}
+				if err := u.Update(testZone, records); err != nil {
+					t.Errorf("%s: expected no error but got: %v", p, err)
+				}
+			}
+
+			// A different key must fail verification.
+			other, otherPriv := newSIG0Key(t, tc.alg, tc.bits)
+			u := NewRFC2136([]string{addr})
+			if err := u.WithSIG0(writeSIG0Key(t, t.TempDir(), other, otherPriv)); err != nil {
+				t.Fatalf("error loading key: %v", err)
+			}
+			if err := u.Update(testZone, records); err == nil {
+				t.Errorf("expected an error with the wrong key")
+			}
+		})
+	}
+}
+
+func TestWithSIG0Error(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewRFC2136(nil).WithSIG0(filepath.Join(dir, "Knotfound.+013+00001.private")); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+
+	notKey := filepath.Join(dir, "Kexample.com.+013+00001")
+	if err := os.WriteFile(notKey+".key", []byte("example.com. 3600 IN A 192.0.2.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRFC2136(nil).WithSIG0(notKey + ".private"); err == nil {
+		t.Errorf("expected an error for a record that is not a KEY")
+	}
+}
+
+// Rejects the first reject updates with BADKEY and always returns SERVFAIL from the servers in servfail.
+type tkeyDNS struct {
+	reject    int
+	servfail  map[string]bool
+	exchanges int
+}
+
+// Exchange implements dnsExchanger
+func (d *tkeyDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	d.exchanges++
+	r := new(dns.Msg).SetReply(msg)
+	if d.reject > 0 {
+		d.reject--
+		r.Rcode = dns.RcodeNotAuth
+		r.Extra = []dns.RR{&dns.TSIG{
+			Hdr:       dns.RR_Header{Name: testTKEY, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm: tsig.GSS,
+			Error:     dns.RcodeBadKey,
+		}}
+	} else if d.servfail[server] {
+		r.Rcode = dns.RcodeServerFailure
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestGSSContextReuse(t *testing.T) {
+	tests := map[string]struct {
+		servers          []string
+		dns              *tkeyDNS
+		lifetime         time.Duration
+		wantNegotiations int
+		wantExchanges    int
+		wantError        bool
+	}{
+		"reuse": {
+			servers:          []string{testNS1},
+			dns:              &tkeyDNS{},
+			wantNegotiations: 1,
+			wantExchanges:    3,
+		},
+		"per server": {
+			servers:          []string{testNS1, testNS2},
+			dns:              &tkeyDNS{servfail: map[string]bool{testNS1: true}},
+			wantNegotiations: 2,
+			wantExchanges:    6,
+		},
+		"expiring": {
+			servers:          []string{testNS1},
+			dns:              &tkeyDNS{},
+			lifetime:         tkeyExpiryMargin / 2,
+			wantNegotiations: 3,
+			wantExchanges:    3,
+		},
+		"badkey": {
+			servers:          []string{testNS1},
+			dns:              &tkeyDNS{reject: 1},
+			wantNegotiations: 2,
+			wantExchanges:    4,
+		},
+		"badkey after renegotiation": {
+			servers:          []string{testNS1},
+			dns:              &tkeyDNS{reject: 2},
+			wantNegotiations: 2,
+			wantExchanges:    2,
+			wantError:        true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := &testGSS{lifetime: tc.lifetime}
+			u := &RFC2136Updater{servers: tc.servers, dns: tc.dns, gss: g}
+
+			for i := 0; i < 3; i++ {
+				err := u.Update(testZone, []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "test"}}})
+				if err != nil {
+					if !tc.wantError {
+						t.Errorf("expected no error but got: %v", err)
+					}
+					break
+				}
+				if tc.wantError {
+					t.Fatalf("expected an error")
+				}
+			}
+			if g.negotiations != tc.wantNegotiations {
+				t.Errorf("got %d negotiations, want %d", g.negotiations, tc.wantNegotiations)
+			}
+			if tc.dns.exchanges != tc.wantExchanges {
+				t.Errorf("got %d exchanges, want %d", tc.dns.exchanges, tc.wantExchanges)
+			}
+
+			if err := u.Close(); err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if len(u.tkeys) != 0 {
+				t.Errorf("expected all contexts to be deleted but got %v", u.tkeys)
+			}
+		})
+	}
+}
@@ -2,7 +2,74 @@ package updater
 
 import "github.com/miekg/dns"
 
+// Updater sends dynamic updates to a zone.
+//
+// The records passed to Update make up the update section of a single
+// UPDATE message. Records are added to the zone unless they have been
+// encoded as deletions with Remove, RemoveRRset or RemoveName.
 type Updater interface {
-	Insert(string, []dns.RR) error
+	Update(string, []dns.RR) error
 	Close() error
 }
+
+// Transferer fetches the current contents of a zone.
+type Transferer interface {
+	Transfer(string) ([]dns.RR, error)
+}
+
+// Remove returns copies of records encoded as deletions of the individual
+// RRs (RFC 2136 section 2.5.4).
+func Remove(records []dns.RR) []dns.RR {
+	ret := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassNONE
+		rr.Header().Ttl = 0
+		ret = append(ret, rr)
+	}
+	return ret
+}
+
+// RemoveRRset returns deletions of the RRsets (RFC 2136 section 2.5.2)
+// that records belong to. Each RRset is only deleted once.
+func RemoveRRset(records []dns.RR) []dns.RR {
+	var ret []dns.RR
+	seen := map[rrsetKey]bool{}
+	for _, rr := range records {
+		h := rr.Header()
+		key := rrsetKey{dns.CanonicalName(h.Name), h.Rrtype}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, &dns.ANY{Hdr: dns.RR_Header{Name: h.Name, Rrtype: h.Rrtype, Class: dns.ClassANY}})
+	}
+	return ret
+}
+
+// Identifies an RRset. name must be canonical.
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// RemoveName returns deletions of all RRsets of names (RFC 2136 section 2.5.3).
+func RemoveName(names []string) []dns.RR {
+	ret := make([]dns.RR, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, &dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeANY, Class: dns.ClassANY}})
+	}
+	return ret
+}
+
+// Returns an UPDATE message for zone with records as the update section.
+func newUpdate(zone string, records []dns.RR) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+	msg.RecursionDesired = false
+	// Not msg.Insert since it would overwrite the class of deletions.
+	msg.Ns = records
+	// So that servers can return extended rcodes and Extended DNS Errors.
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+	return msg
+}
@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRemove(t *testing.T) {
+	rr := &dns.A{
+		Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(192, 0, 2, 1),
+	}
+	have := Remove([]dns.RR{rr})
+	if len(have) != 1 {
+		t.Fatalf("got %d records, want 1", len(have))
+	}
+	if h := have[0].Header(); h.Class != dns.ClassNONE || h.Ttl != 0 {
+		t.Errorf("got class %d and TTL %d, want class NONE and TTL 0", h.Class, h.Ttl)
+	}
+	if rr.Hdr.Class != dns.ClassINET || rr.Hdr.Ttl != 300 {
+		t.Errorf("expected the original record to be unchanged but got %v", rr)
+	}
+}
+
+func TestRemoveRRset(t *testing.T) {
+	records := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 1)},
+		&dns.A{Hdr: dns.RR_Header{Name: "A.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(192, 0, 2, 2)},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("2001:db8::1")},
+	}
+	want := []string{
+		"a.example.com.\t0\tCLASS255\tA\t",
+		"a.example.com.\t0\tCLASS255\tAAAA\t",
+	}
+	have := RemoveRRset(records)
+	if len(have) != len(want) {
+		t.Fatalf("got %d records, want %d: %v", len(have), len(want), have)
+	}
+	for i, rr := range have {
+		if rr.String() != want[i] {
+			t.Errorf("%d: got %q, want %q", i, rr, want[i])
+		}
+	}
+}
+
+func TestRemoveName(t *testing.T) {
+	want := "a.example.com.\t0\tCLASS255\tANY\t"
+	have := RemoveName([]string{"a.example.com."})
+	if len(have) != 1 {
+		t.Fatalf("got %d records, want 1", len(have))
+	}
+	if have[0].String() != want {
+		t.Errorf("got %q, want %q", have[0], want)
+	}
+}
@@ -0,0 +1,190 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// WithVerify queries the server that accepted each update for the RRsets
+// in the update and returns an error if they don't match it.
+func (u *RFC2136Updater) WithVerify() {
+	u.verify = true
+}
+
+// The expected state of an RRset after an update.
+type rrsetState struct {
+	name   string
+	rrtype uint16
+	// Records that must be in the RRset.
+	present []dns.RR
+	// Records that must not be in the RRset.
+	absent []dns.RR
+	// If true, the RRset must only contain present.
+	exact bool
+}
+
+// Returns the expected state of each RRset changed by records
+// in the order that they first appear.
+// Deletions of all RRsets at a name are not verified.
+func expectedRRsets(records []dns.RR) []*rrsetState {
+	var states []*rrsetState
+	byKey := map[rrsetKey]*rrsetState{}
+	for _, rr := range records {
+		h := rr.Header()
+		if h.Class == dns.ClassANY && h.Rrtype == dns.TypeANY {
+			states = slices.DeleteFunc(states, func(s *rrsetState) bool {
+				return strings.EqualFold(s.name, h.Name)
+			})
+			for k := range byKey {
+				if strings.EqualFold(k.name, h.Name) {
+					delete(byKey, k)
+				}
+			}
+			continue
+		}
+
+		k := rrsetKey{name: dns.CanonicalName(h.Name), rrtype: h.Rrtype}
+		s, ok := byKey[k]
+		if !ok {
+			s = &rrsetState{name: h.Name, rrtype: h.Rrtype}
+			byKey[k] = s
+			states = append(states, s)
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			s.present, s.absent, s.exact = nil, nil, true
+		case dns.ClassNONE:
+			// So that it can be compared with the records in the zone.
+			rr = dns.Copy(rr)
+			rr.Header().Class = dns.ClassINET
+			s.present = slices.DeleteFunc(s.present, func(p dns.RR) bool { return dns.IsDuplicate(p, rr) })
+			s.absent = append(s.absent, rr)
+		default:
+			s.absent = slices.DeleteFunc(s.absent, func(a dns.RR) bool { return dns.IsDuplicate(a, rr) })
+			s.present = append(s.present, rr)
+		}
+	}
+	return states
+}
+
+// Query server for each RRset changed by records and compare them to the update.
+func (u *RFC2136Updater) verifyUpdate(server string, records []dns.RR) error {
+	var errs []error
+	for _, s := range expectedRRsets(records) {
+		got, err := u.query(server, s.name, s.rrtype)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("verify %s: %w", server, err))
+			continue
+		}
+		if !s.matches(got) {
+			errs = append(errs, &verifyError{server: server, state: s, got: got})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the records of type t owned by name on server
+// using a query signed like updates.
+func (u *RFC2136Updater) query(server string, name string, t uint16) ([]dns.RR, error) {
+	tkey, err := u.getTKEY(server)
+	if err != nil {
+		return nil, fmt.Errorf("tkey %s: %w", server, err)
+	}
+	msg := newQuery(name, t)
+	if err := u.sign(msg, tkey); err != nil {
+		return nil, err
+	}
+	return u.exchangeQuery(server, msg, 0)
+}
+
+// Like query but without signing, for servers that may not have the key.
+func (u *RFC2136Updater) queryUnsigned(server string, name string, t uint16) ([]dns.RR, error) {
+	return u.exchangeQuery(server, newQuery(name, t), 0)
+}
+
+// Like queryUnsigned, but waits at most timeout for the response unless it is 0.
+func (u *RFC2136Updater) queryUnsignedTimeout(server string, name string, t uint16, timeout time.Duration) ([]dns.RR, error) {
+	return u.exchangeQuery(server, newQuery(name, t), timeout)
+}
+
+func newQuery(name string, t uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), t)
+	msg.RecursionDesired = false
+	return msg
+}
+
+// Send the query msg to server and return the answers matching its question.
+// Waits at most timeout for the response unless it is 0.
+func (u *RFC2136Updater) exchangeQuery(server string, msg *dns.Msg, timeout time.Duration) ([]dns.RR, error) {
+	q := msg.Question[0]
+	r, err := u.exchangeTimeout(msg, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s %s: got rcode %s", q.Name, dns.TypeToString[q.Qtype], dns.RcodeToString[r.Rcode])
+	}
+	var records []dns.RR
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == q.Qtype && strings.EqualFold(rr.Header().Name, q.Name) {
+			records = append(records, rr)
+		}
+	}
+	return records, nil
+}
+
+// Returns true if got satisfies s. TTLs must match for present records.
+func (s *rrsetState) matches(got []dns.RR) bool {
+	for _, want := range s.present {
+		i := slices.IndexFunc(got, func(rr dns.RR) bool { return dns.IsDuplicate(rr, want) })
+		if i == -1 || got[i].Header().Ttl != want.Header().Ttl {
+			return false
+		}
+	}
+	for _, a := range s.absent {
+		if slices.ContainsFunc(got, func(rr dns.RR) bool { return dns.IsDuplicate(rr, a) }) {
+			return false
+		}
+	}
+	if s.exact {
+		for _, rr := range got {
+			if !slices.ContainsFunc(s.present, func(p dns.RR) bool { return dns.IsDuplicate(rr, p) }) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// An RRset on a server that doesn't match the update sent to it.
+type verifyError struct {
+	server string
+	state  *rrsetState
+	got    []dns.RR
+}
+
+func (e *verifyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "verify %s: %s %s does not match the update: got [%s]", e.server, e.state.name, dns.TypeToString[e.state.rrtype], rrList(e.got))
+	if len(e.state.present) > 0 {
+		fmt.Fprintf(&b, ", want [%s]", rrList(e.state.present))
+	}
+	if len(e.state.absent) > 0 {
+		fmt.Fprintf(&b, ", want absent [%s]", rrList(e.state.absent))
+	}
+	return b.String()
+}
+
+func rrList(records []dns.RR) string {
+	s := make([]string, 0, len(records))
+	for _, rr := range records {
+		s = append(s, rr.String())
+	}
+	return strings.Join(s, "; ")
+}
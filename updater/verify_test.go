@@ -0,0 +1,126 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Accepts all updates without applying them and answers queries from zone.
+type verifyDNS struct {
+	zone    []dns.RR
+	queries []string
+}
+
+// Exchange implements dnsExchanger
+func (d *verifyDNS) Exchange(msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	r := new(dns.Msg).SetReply(msg)
+	if msg.Opcode == dns.OpcodeUpdate {
+		return r, time.Millisecond, nil
+	}
+	q := msg.Question[0]
+	d.queries = append(d.queries, server+" "+q.Name+" "+dns.TypeToString[q.Qtype])
+	for _, rr := range d.zone {
+		if strings.EqualFold(rr.Header().Name, q.Name) && rr.Header().Rrtype == q.Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	return r, time.Millisecond, nil
+}
+
+func TestVerify(t *testing.T) {
+	a1 := mustRR("www.example.com. 300 IN A 192.0.2.1")
+	a2 := mustRR("www.example.com. 300 IN A 192.0.2.2")
+	a1TTL := mustRR("www.example.com. 60 IN A 192.0.2.1")
+	txt := mustRR(`www.example.com. 300 IN TXT "hello"`)
+
+	tests := map[string]struct {
+		zone      []dns.RR
+		update    []dns.RR
+		wantError bool
+	}{
+		"added": {
+			zone:   []dns.RR{a1, a2},
+			update: []dns.RR{a1},
+		},
+		"missing": {
+			zone:      []dns.RR{a2},
+			update:    []dns.RR{a1},
+			wantError: true,
+		},
+		"ttl": {
+			zone:      []dns.RR{a1TTL},
+			update:    []dns.RR{a1},
+			wantError: true,
+		},
+		"replaced": {
+			zone:   []dns.RR{a1, txt},
+			update: append(RemoveRRset([]dns.RR{a1}), a1),
+		},
+		"replaced extra": {
+			zone:      []dns.RR{a1, a2},
+			update:    append(RemoveRRset([]dns.RR{a1}), a1),
+			wantError: true,
+		},
+		"removed": {
+			zone:   []dns.RR{a2},
+			update: Remove([]dns.RR{a1}),
+		},
+		"not removed": {
+			zone:      []dns.RR{a1},
+			update:    Remove([]dns.RR{a1}),
+			wantError: true,
+		},
+		"rrset not removed": {
+			zone:      []dns.RR{a1},
+			update:    RemoveRRset([]dns.RR{a1}),
+			wantError: true,
+		},
+		"removed then added": {
+			zone:   []dns.RR{a1},
+			update: append(Remove([]dns.RR{a1}), a1),
+		},
+		"name removed": {
+			zone:   []dns.RR{a1},
+			update: RemoveName([]string{"www.example.com."}),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &verifyDNS{zone: tc.zone}
+			u := &RFC2136Updater{servers: []string{testNS1, testNS2}, dns: d}
+			u.WithVerify()
+
+			err := u.Update(testZone, tc.update)
+			if err == nil && tc.wantError {
+				t.Errorf("expected an error")
+			} else if err != nil && !tc.wantError {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			for _, q := range d.queries {
+				if !strings.HasPrefix(q, testNS1+" ") {
+					t.Errorf("expected queries to be sent to %s but got %q", testNS1, q)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyError(t *testing.T) {
+	e := &verifyError{
+		server: testNS1,
+		state: &rrsetState{
+			name:    "www.example.com.",
+			rrtype:  dns.TypeA,
+			present: []dns.RR{mustRR("www.example.com. 300 IN A 192.0.2.1")},
+		},
+		got: []dns.RR{mustRR("www.example.com. 300 IN A 192.0.2.2")},
+	}
+	want := "verify ns1.example.com: www.example.com. A does not match the update: " +
+		"got [www.example.com.\t300\tIN\tA\t192.0.2.2], want [www.example.com.\t300\tIN\tA\t192.0.2.1]"
+	if have := e.Error(); have != want {
+		t.Errorf("got %q, want %q", have, want)
+	}
+}
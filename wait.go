@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/config"
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+type propagationWaiter interface {
+	WaitForPropagation(zone string, records []dns.RR, servers []string, timeout time.Duration) error
+}
+
+// waitUpdater remembers the records of the successful updates to each zone
+// so that waitZone can wait for them to propagate.
+type waitUpdater struct {
+	updater.Updater
+	w       propagationWaiter
+	timeout time.Duration
+	// By canonical zone name. Empty to use the zone's NS records.
+	servers map[string][]string
+
+	mu      sync.Mutex
+	records map[string][]dns.RR
+}
+
+func newWaitUpdater(s updater.Updater, w propagationWaiter, c *config.Config, timeout time.Duration) *waitUpdater {
+	u := &waitUpdater{
+		Updater: s,
+		w:       w,
+		timeout: timeout,
+		servers: map[string][]string{},
+		records: map[string][]dns.RR{},
+	}
+	for name, z := range c.Zones {
+		servers := z.WaitServers
+		if len(servers) == 0 {
+			servers = c.WaitServers
+		}
+		u.servers[dns.CanonicalName(name)] = servers
+	}
+	return u
+}
+
+// Update implements updater.Updater
+func (u *waitUpdater) Update(zone string, records []dns.RR) error {
+	if err := u.Updater.Update(zone, records); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	zone = dns.CanonicalName(zone)
+	u.records[zone] = append(u.records[zone], records...)
+	return nil
+}
+
+// Wait for the records sent to zone so far to propagate.
+func (u *waitUpdater) wait(zone string) error {
+	zone = dns.CanonicalName(zone)
+	u.mu.Lock()
+	records := u.records[zone]
+	delete(u.records, zone)
+	u.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	return u.w.WaitForPropagation(zone, records, u.servers[zone], u.timeout)
+}
+
+// Wait for the updates to zone to propagate if s is a waitUpdater.
+func waitZone(s updater.Updater, zone string, logger *slog.Logger) int {
+	w, ok := s.(*waitUpdater)
+	if !ok {
+		return 0
+	}
+	logger.Info("Waiting for the updates to propagate")
+	if err := w.wait(zone); err != nil {
+		logger.Error("Updates did not propagate", "err", err)
+		return handleError()
+	}
+	return 0
+}
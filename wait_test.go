@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/config"
+
+	"github.com/miekg/dns"
+)
+
+type testWaiter struct {
+	fail    bool
+	waits   map[string][]dns.RR
+	servers map[string][]string
+}
+
+// WaitForPropagation implements propagationWaiter
+func (w *testWaiter) WaitForPropagation(zone string, records []dns.RR, servers []string, timeout time.Duration) error {
+	if w.waits == nil {
+		w.waits = map[string][]dns.RR{}
+		w.servers = map[string][]string{}
+	}
+	w.waits[zone] = records
+	w.servers[zone] = servers
+	if w.fail {
+		return errors.New("not propagated")
+	}
+	return nil
+}
+
+func TestWaitUpdater(t *testing.T) {
+	c := &config.Config{
+		WaitServers: []string{"192.0.2.53:53"},
+		Zones: map[string]*config.Zone{
+			"example.com": {Records: map[string]*config.Record{
+				"www": {FQDN: "www.example.com.", Host: mustParseIPs("192.0.2.1")},
+			}},
+			"example.net": {
+				WaitServers: []string{"198.51.100.53:53"},
+				Records: map[string]*config.Record{
+					"www": {FQDN: "www.example.net.", Host: mustParseIPs("192.0.2.1")},
+				},
+			},
+			"example.org": {Records: map[string]*config.Record{
+				"www": {FQDN: "www.example.org.", State: config.StateAbsent},
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		fail      bool
+		batchSize int
+		want      int
+	}{
+		"per name": {},
+		"batch":    {batchSize: 10},
+		"fail":     {fail: true, want: 3},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := &testWaiter{fail: tc.fail}
+			s := newWaitUpdater(&testUpdater{}, w, c, time.Minute)
+			if have := update(context.Background(), s, c.Zones, tc.batchSize, 0, recordUpdate); have != tc.want {
+				t.Errorf("got %d errors, want %d", have, tc.want)
+			}
+
+			wantRecords := map[string][]string{
+				"example.com.": {"www.example.com.\t0\tIN\tA\t192.0.2.1"},
+				"example.net.": {"www.example.net.\t0\tIN\tA\t192.0.2.1"},
+				"example.org.": {"www.example.org.\t0\tCLASS255\tANY\t"},
+			}
+			for zone, want := range wantRecords {
+				if have := rrStrings(w.waits[zone]); !reflect.DeepEqual(have, want) {
+					t.Errorf("%s: got %v, want %v", zone, have, want)
+				}
+			}
+			wantServers := map[string][]string{
+				"example.com.": {"192.0.2.53:53"},
+				"example.net.": {"198.51.100.53:53"},
+				"example.org.": {"192.0.2.53:53"},
+			}
+			if !reflect.DeepEqual(w.servers, wantServers) {
+				t.Errorf("got servers %v, want %v", w.servers, wantServers)
+			}
+			if len(s.records) != 0 {
+				t.Errorf("expected the records to be cleared after waiting but got %v", s.records)
+			}
+		})
+	}
+}
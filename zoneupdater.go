@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/devon-mar/dnsupdater/updater"
+
+	"github.com/miekg/dns"
+)
+
+type zoneClient interface {
+	updater.Updater
+	updater.Transferer
+	serverUpdater
+	propagationWaiter
+	zoneFinder
+}
+
+// zoneUpdater sends the requests for each zone to the zone's client,
+// or to the default client if the zone doesn't have one.
+type zoneUpdater struct {
+	def zoneClient
+	// By canonical zone name.
+	zones map[string]zoneClient
+}
+
+func (u *zoneUpdater) client(zone string) zoneClient {
+	if c, ok := u.zones[dns.CanonicalName(zone)]; ok {
+		return c
+	}
+	return u.def
+}
+
+// Update implements updater.Updater
+func (u *zoneUpdater) Update(zone string, records []dns.RR) error {
+	return u.client(zone).Update(zone, records)
+}
+
+// UpdateServer implements serverUpdater
+func (u *zoneUpdater) UpdateServer(zone string, records []dns.RR) (string, error) {
+	return u.client(zone).UpdateServer(zone, records)
+}
+
+// Transfer implements updater.Transferer
+func (u *zoneUpdater) Transfer(zone string) ([]dns.RR, error) {
+	return u.client(zone).Transfer(zone)
+}
+
+// WaitForPropagation implements propagationWaiter
+func (u *zoneUpdater) WaitForPropagation(zone string, records []dns.RR, servers []string, timeout time.Duration) error {
+	return u.client(zone).WaitForPropagation(zone, records, servers, timeout)
+}
+
+// FindZone implements zoneFinder
+func (u *zoneUpdater) FindZone(name string) (string, error) {
+	return u.def.FindZone(name)
+}
+
+// Close implements updater.Updater
+func (u *zoneUpdater) Close() error {
+	errs := []error{u.def.Close()}
+	for _, c := range u.zones {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
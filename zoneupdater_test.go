@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type testZoneClient struct {
+	*testUpdater
+	*testTransferer
+}
+
+// WaitForPropagation implements propagationWaiter
+func (testZoneClient) WaitForPropagation(string, []dns.RR, []string, time.Duration) error {
+	return nil
+}
+
+// UpdateServer implements serverUpdater
+func (c testZoneClient) UpdateServer(zone string, records []dns.RR) (string, error) {
+	return "", c.Update(zone, records)
+}
+
+// FindZone implements zoneFinder
+func (testZoneClient) FindZone(string) (string, error) {
+	return "", errors.New("FindZone not implemented")
+}
+
+func TestZoneUpdater(t *testing.T) {
+	def := testZoneClient{&testUpdater{}, &testTransferer{zones: map[string][]dns.RR{"example.com.": testSyncZone}}}
+	rev := testZoneClient{&testUpdater{}, &testTransferer{}}
+	u := &zoneUpdater{
+		def:   def,
+		zones: map[string]zoneClient{"2.0.192.in-addr.arpa.": rev},
+	}
+
+	tests := map[string]struct {
+		zone string
+		want *testUpdater
+	}{
+		"default":    {zone: "example.com.", want: def.testUpdater},
+		"zone":       {zone: "2.0.192.in-addr.arpa.", want: rev.testUpdater},
+		"zone case":  {zone: "2.0.192.IN-ADDR.ARPA.", want: rev.testUpdater},
+		"other zone": {zone: "example.net.", want: def.testUpdater},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := u.Update(tc.zone, nil); err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if _, ok := tc.want.insertions[tc.zone]; !ok {
+				t.Errorf("expected an update for %s", tc.zone)
+			}
+		})
+	}
+
+	if _, err := u.Transfer("example.com."); err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+	if _, err := u.Transfer("2.0.192.in-addr.arpa."); err == nil {
+		t.Errorf("expected transfer of the reverse zone to use its client")
+	}
+	if err := u.Close(); err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+}